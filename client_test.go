@@ -1,8 +1,10 @@
 package redismock
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -77,6 +79,75 @@ var _ = Describe("Client", func() {
 		})
 	})
 
+	Describe("tx pipeline queued", func() {
+		var pipe redis.Pipeliner
+
+		BeforeEach(func() {
+			pipe = client.TxPipeline()
+		})
+
+		It("syntax error", func() {
+			clientMock.ExpectTxPipeline()
+			clientMock.ExpectGet("key1").SetVal("1")
+			clientMock.ExpectSet("key2", "value", 0).SetQueueErr(errors.New("ERR syntax error"))
+			clientMock.ExpectTxPipelineExec()
+
+			get := pipe.Get(ctx, "key1")
+			set := pipe.Set(ctx, "key2", "value", 0)
+
+			_, err := pipe.Exec(ctx)
+			Expect(redis.HasErrorPrefix(err, "EXECABORT")).To(BeTrue())
+
+			Expect(redis.HasErrorPrefix(get.Err(), "EXECABORT")).To(BeTrue())
+			Expect(get.Val()).To(Equal(""))
+			Expect(redis.HasErrorPrefix(set.Err(), "EXECABORT")).To(BeTrue())
+		})
+
+		It("unexpected cmd", func() {
+			clientMock.ExpectTxPipeline()
+			clientMock.ExpectGet("key1").SetVal("1")
+			clientMock.ExpectTxPipelineExec()
+
+			get := pipe.Get(ctx, "key1")
+			incr := pipe.Incr(ctx, "key2")
+
+			_, err := pipe.Exec(ctx)
+			Expect(err).To(Equal(incr.Err()))
+			Expect(redis.HasErrorPrefix(incr.Err(), "EXECABORT")).To(BeFalse())
+			Expect(redis.HasErrorPrefix(get.Err(), "EXECABORT")).To(BeTrue())
+		})
+
+		It("runtime error", func() {
+			wrongType := errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+			clientMock.ExpectTxPipeline()
+			clientMock.ExpectIncr("key1").SetErr(wrongType)
+			clientMock.ExpectSet("key2", "value", 0).SetVal("OK")
+			clientMock.ExpectTxPipelineExec()
+
+			incr := pipe.Incr(ctx, "key1")
+			set := pipe.Set(ctx, "key2", "value", 0)
+
+			_, err := pipe.Exec(ctx)
+			Expect(err).To(Equal(wrongType))
+			Expect(incr.Err()).To(Equal(wrongType))
+			Expect(set.Err()).NotTo(HaveOccurred())
+			Expect(set.Val()).To(Equal("OK"))
+		})
+
+		It("exec abort", func() {
+			clientMock.ExpectTxPipeline()
+			clientMock.ExpectGet("key1").SetVal("1")
+			clientMock.ExpectTxPipelineExec().Abort()
+
+			get := pipe.Get(ctx, "key1")
+
+			_, err := pipe.Exec(ctx)
+			Expect(err).To(Equal(redis.TxFailedErr))
+			Expect(get.Err()).To(Equal(redis.TxFailedErr))
+			Expect(get.Val()).To(Equal(""))
+		})
+	})
+
 	Describe("pipeline", func() {
 		var pipe redis.Pipeliner
 
@@ -129,6 +200,186 @@ var _ = Describe("Client", func() {
 		})
 	})
 
+	Describe("pipeline partial failure", func() {
+		BeforeEach(func() {
+			clientMock.ExpectGet("key1").SetVal("pipeline get")
+			clientMock.ExpectIncr("key2").SetErr(errors.New("ERR value is not an integer or out of range"))
+			clientMock.ExpectGet("key3").RedisNil()
+			clientMock.ExpectSet("key4", "value", 0).SetVal("OK")
+		})
+
+		It("every cmd has its own reply", func() {
+			pipe := client.Pipeline()
+			get := pipe.Get(ctx, "key1")
+			incr := pipe.Incr(ctx, "key2")
+			nilGet := pipe.Get(ctx, "key3")
+			set := pipe.Set(ctx, "key4", "value", 0)
+
+			cmds, err := pipe.Exec(ctx)
+			Expect(err).To(Equal(errors.New("ERR value is not an integer or out of range")))
+			Expect(cmds).To(Equal([]redis.Cmder{get, incr, nilGet, set}))
+
+			Expect(get.Err()).NotTo(HaveOccurred())
+			Expect(get.Val()).To(Equal("pipeline get"))
+
+			Expect(incr.Err()).To(Equal(errors.New("ERR value is not an integer or out of range")))
+
+			Expect(nilGet.Err()).To(Equal(redis.Nil))
+
+			Expect(set.Err()).NotTo(HaveOccurred())
+			Expect(set.Val()).To(Equal("OK"))
+		})
+
+		It("unexpected cmd in the middle", func() {
+			clientMock.MatchExpectationsInOrder(false)
+
+			pipe := client.Pipeline()
+			get := pipe.Get(ctx, "key1")
+			hGet := pipe.HGet(ctx, "key", "field")
+			incr := pipe.Incr(ctx, "key2")
+			nilGet := pipe.Get(ctx, "key3")
+			set := pipe.Set(ctx, "key4", "value", 0)
+
+			_, err := pipe.Exec(ctx)
+			Expect(err).To(Equal(hGet.Err()))
+
+			Expect(get.Val()).To(Equal("pipeline get"))
+			Expect(hGet.Err()).To(HaveOccurred())
+			Expect(incr.Err()).To(HaveOccurred())
+			Expect(nilGet.Err()).To(Equal(redis.Nil))
+			Expect(set.Val()).To(Equal("OK"))
+		})
+	})
+
+	Describe("pipeline group", func() {
+		BeforeEach(func() {
+			clientMock.ExpectPipeline(func(p PipelineExpectations) {
+				p.ExpectGet("key1").SetVal("pipeline get")
+				p.ExpectSet("set_key", "set value", 1*time.Minute).SetVal("OK")
+			})
+		})
+
+		It("one exec", func() {
+			pipe := client.Pipeline()
+			get := pipe.Get(ctx, "key1")
+			set := pipe.Set(ctx, "set_key", "set value", 1*time.Minute)
+
+			cmds, err := pipe.Exec(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cmds).To(HaveLen(2))
+			Expect(get.Val()).To(Equal("pipeline get"))
+			Expect(set.Val()).To(Equal("OK"))
+		})
+
+		It("not batched", func() {
+			get := client.Get(ctx, "key1")
+			Expect(get.Err()).To(HaveOccurred())
+
+			pipe := client.Pipeline()
+			pipe.Get(ctx, "key1")
+			_, err := pipe.Exec(ctx)
+			Expect(err).To(HaveOccurred())
+
+			Expect(clientMock.ExpectationsWereMet()).To(HaveOccurred())
+			clientMock.ClearExpect()
+		})
+
+		It("unordered", func() {
+			clientMock.ClearExpect()
+			clientMock.ExpectPipeline(func(p PipelineExpectations) {
+				p.ExpectGet("key1").SetVal("pipeline get")
+				p.ExpectSet("set_key", "set value", 1*time.Minute).SetVal("OK")
+			}).Unordered()
+
+			pipe := client.Pipeline()
+			set := pipe.Set(ctx, "set_key", "set value", 1*time.Minute)
+			get := pipe.Get(ctx, "key1")
+
+			_, err := pipe.Exec(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(get.Val()).To(Equal("pipeline get"))
+			Expect(set.Val()).To(Equal("OK"))
+		})
+
+		It("ordered", func() {
+			pipe := client.Pipeline()
+			pipe.Set(ctx, "set_key", "set value", 1*time.Minute)
+			pipe.Get(ctx, "key1")
+
+			_, err := pipe.Exec(ctx)
+			Expect(err).To(HaveOccurred())
+			clientMock.ClearExpect()
+		})
+
+		It("times", func() {
+			clientMock.ClearExpect()
+			var e *ExpectedString
+			clientMock.With(Times(2)).ExpectPipeline(func(p PipelineExpectations) {
+				e = p.ExpectGet("key1")
+				e.SetVal("pipeline get")
+			})
+
+			for i := 0; i < 2; i++ {
+				Expect(e.Done()).NotTo(BeClosed())
+				pipe := client.Pipeline()
+				get := pipe.Get(ctx, "key1")
+				_, err := pipe.Exec(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(get.Val()).To(Equal("pipeline get"))
+			}
+			Expect(e.Done()).To(BeClosed())
+			Expect(clientMock.ExpectationsWereMet()).NotTo(HaveOccurred())
+		})
+
+		It("shares calls and watches", func() {
+			clientMock.ClearExpect()
+			var group PipelineExpectations
+			clientMock.ExpectPipeline(func(p PipelineExpectations) {
+				group = p
+				p.ExpectDo("watch", "key").SetVal("OK")
+				p.ExpectGet("key1").SetVal("pipeline get")
+			})
+			clientMock.ExpectTxPipeline()
+			clientMock.ExpectGet("key1").SetVal("tx get")
+			clientMock.ExpectTxPipelineExec()
+
+			pipe := client.Pipeline()
+			pipe.Do(ctx, "watch", "key")
+			pipe.Get(ctx, "key1")
+			_, err := pipe.Exec(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(group.Calls()).To(HaveLen(2))
+
+			clientMock.ModifyWatchedKeys("key")
+			_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Get(ctx, "key1")
+				return nil
+			})
+			Expect(err).To(Equal(redis.TxFailedErr))
+		})
+
+		It("concurrent exec", func() {
+			errs := make(chan error, 2)
+			for i := 0; i < 2; i++ {
+				go func() {
+					pipe := client.Pipeline()
+					pipe.Get(ctx, "key1")
+					pipe.Set(ctx, "set_key", "set value", 1*time.Minute)
+					_, err := pipe.Exec(ctx)
+					errs <- err
+				}()
+			}
+
+			var failed int
+			for i := 0; i < 2; i++ {
+				if <-errs != nil {
+					failed++
+				}
+			}
+			Expect(failed).To(Equal(1))
+		})
+	})
+
 	Describe("watch", func() {
 		BeforeEach(func() {
 			clientMock.ExpectWatch("key1", "key2")
@@ -195,6 +446,678 @@ var _ = Describe("Client", func() {
 		})
 	})
 
+	Describe("watch conflict", func() {
+		txf := func(tx *redis.Tx) error {
+			n, err := tx.Get(ctx, "key").Int()
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, "key", n+1, 0)
+				return nil
+			})
+			return err
+		}
+
+		BeforeEach(func() {
+			clientMock.ExpectWatch("key")
+			clientMock.ExpectGet("key").SetVal("1")
+			clientMock.ExpectTxPipeline()
+			clientMock.ExpectSet("key", 2, 0).SetVal("OK")
+		})
+
+		It("exec abort", func() {
+			clientMock.ExpectTxPipelineExec().Abort()
+			clientMock.ExpectUnwatch()
+
+			err := client.Watch(ctx, txf, "key")
+			Expect(err).To(Equal(redis.TxFailedErr))
+		})
+
+		It("retry after abort", func() {
+			clientMock.ExpectTxPipelineExec().Abort()
+			clientMock.ExpectUnwatch()
+			clientMock.ExpectWatch("key")
+			clientMock.ExpectGet("key").SetVal("1")
+			clientMock.ExpectTxPipeline()
+			clientMock.ExpectSet("key", 2, 0).SetVal("OK")
+			clientMock.ExpectTxPipelineExec()
+			clientMock.ExpectUnwatch()
+
+			var err error
+			for i := 0; i < 3; i++ {
+				if err = client.Watch(ctx, txf, "key"); err != redis.TxFailedErr {
+					break
+				}
+			}
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("key modified by another client", func() {
+			clientMock.ExpectTxPipelineExec()
+			clientMock.ExpectUnwatch()
+
+			err := client.Watch(ctx, func(tx *redis.Tx) error {
+				clientMock.ModifyWatchedKeys("other")
+				clientMock.ModifyWatchedKeys("key")
+				return txf(tx)
+			}, "key")
+			Expect(err).To(Equal(redis.TxFailedErr))
+		})
+
+		It("key not watched", func() {
+			clientMock.ExpectTxPipelineExec()
+			clientMock.ExpectUnwatch()
+
+			clientMock.ModifyWatchedKeys("key")
+			err := client.Watch(ctx, func(tx *redis.Tx) error {
+				clientMock.ModifyWatchedKeys("other")
+				return txf(tx)
+			}, "key")
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Describe("script", func() {
+		script := redis.NewScript(`return redis.call("GET", KEYS[1])`)
+
+		It("evalsha", func() {
+			clientMock.ExpectScript(script, []string{"key"}, "arg").SetVal("value")
+
+			val, err := script.Run(ctx, client, []string{"key"}, "arg").Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(val).To(Equal("value"))
+		})
+
+		It("eval", func() {
+			clientMock.ExpectScript(script, []string{"key"}, "arg").SetVal("value")
+
+			val, err := script.Eval(ctx, client, []string{"key"}, "arg").Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(val).To(Equal("value"))
+		})
+
+		It("cold script cache", func() {
+			clientMock.ExpectScript(script, []string{"key"}, "arg").NoScript().SetVal("value")
+
+			Expect(redis.HasErrorPrefix(script.EvalSha(ctx, client, []string{"key"}, "arg").Err(), "NOSCRIPT")).To(BeTrue())
+			Expect(clientMock.ExpectationsWereMet()).To(HaveOccurred())
+
+			val, err := script.Eval(ctx, client, []string{"key"}, "arg").Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(val).To(Equal("value"))
+		})
+
+		It("run with cold script cache", func() {
+			clientMock.ExpectScript(script, []string{"key"}, "arg").NoScript().RedisNil()
+
+			err := script.Run(ctx, client, []string{"key"}, "arg").Err()
+			Expect(err).To(Equal(redis.Nil))
+		})
+
+		It("sha1 not match", func() {
+			clientMock.ExpectScript(script, []string{"key"}, "arg").SetVal("value")
+
+			other := redis.NewScript(`return redis.call("GET", KEYS[2])`)
+			err := other.EvalSha(ctx, client, []string{"key"}, "arg").Err()
+			Expect(err).To(MatchError(ContainSubstring("script sha1 not match")))
+
+			err = other.Eval(ctx, client, []string{"key"}, "arg").Err()
+			Expect(err).To(HaveOccurred())
+			clientMock.ClearExpect()
+		})
+	})
+
+	Describe("blocking xread", func() {
+		var streams []redis.XStream
+
+		BeforeEach(func() {
+			streams = []redis.XStream{{
+				Stream:   "events",
+				Messages: []redis.XMessage{{ID: "1-0", Values: map[string]interface{}{"k": "v"}}},
+			}}
+		})
+
+		It("message arrival", func() {
+			args := &redis.XReadArgs{Streams: []string{"events", "$"}, Block: 5 * time.Second}
+			arrival := clientMock.ExpectXRead(args).Block()
+
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				arrival.Push(streams...)
+			}()
+
+			val, err := client.XRead(ctx, args).Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(val).To(Equal(streams))
+		})
+
+		It("pushed before read", func() {
+			args := &redis.XReadGroupArgs{Group: "group", Consumer: "c1", Streams: []string{"events", ">"}, Block: time.Second}
+			clientMock.ExpectXReadGroup(args).Block().Push(streams...)
+
+			val, err := client.XReadGroup(ctx, args).Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(val).To(Equal(streams))
+		})
+
+		It("block timeout", func() {
+			args := &redis.XReadArgs{Streams: []string{"events", "$"}, Block: 50 * time.Millisecond}
+			clientMock.ExpectXRead(args).Block()
+
+			start := time.Now()
+			err := client.XRead(ctx, args).Err()
+			Expect(err).To(Equal(redis.Nil))
+			Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+		})
+
+		It("context canceled", func() {
+			args := &redis.XReadArgs{Streams: []string{"events", "$"}, Block: 0}
+			clientMock.ExpectXRead(args).Block()
+
+			cancelCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+			defer cancel()
+
+			err := client.XRead(cancelCtx, args).Err()
+			Expect(err).To(Equal(context.DeadlineExceeded))
+		})
+	})
+
+	Describe("blocking pop", func() {
+		It("release", func() {
+			e := clientMock.ExpectBLPop(5*time.Second, "queue")
+			e.SetVal([]string{"queue", "job"})
+			pop := e.Block()
+
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				pop.Release()
+			}()
+
+			val, err := client.BLPop(ctx, 5*time.Second, "queue").Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(val).To(Equal([]string{"queue", "job"}))
+		})
+
+		It("timeout", func() {
+			// the timeout sent with the command applies, the expectation would block forever
+			e := clientMock.CustomMatch(func(expected, actual []interface{}) error {
+				return nil
+			}).ExpectBZPopMin(0, "zset")
+			e.SetVal(&redis.ZWithKey{Key: "zset"})
+			e.Block()
+
+			start := time.Now()
+			err := client.BZPopMin(ctx, time.Second, "zset").Err()
+			Expect(err).To(Equal(redis.Nil))
+			Expect(time.Since(start)).To(BeNumerically(">=", time.Second))
+		})
+
+		It("not a blocking pop", func() {
+			e := clientMock.ExpectLRange("queue", 0, -1)
+			Expect(func() { e.Block() }).To(PanicWith("redismock: Block is only supported by the expectations of blocking pops"))
+			clientMock.ClearExpect()
+		})
+
+		It("context canceled", func() {
+			e := clientMock.ExpectBLMove("source", "dest", "LEFT", "RIGHT", 0)
+			e.SetVal("job")
+			e.Block()
+
+			cancelCtx, cancel := context.WithCancel(ctx)
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				cancel()
+			}()
+
+			err := client.BLMove(cancelCtx, "source", "dest", "LEFT", "RIGHT", 0).Err()
+			Expect(err).To(Equal(context.Canceled))
+		})
+	})
+
+	Describe("conn", func() {
+
+		It("scoped to the dialed connection", func() {
+			tenant1 := clientMock.ExpectConn()
+			tenant1.ExpectSelect(1).SetVal("OK")
+			tenant1.ExpectGet("key").SetVal("value1")
+
+			tenant2 := clientMock.ExpectConn()
+			tenant2.ExpectSelect(2).SetVal("OK")
+			tenant2.ExpectGet("key").SetVal("value2")
+
+			conn1 := client.Conn()
+			defer conn1.Close()
+			conn2 := client.Conn()
+			defer conn2.Close()
+
+			Expect(conn1.Select(ctx, 1).Val()).To(Equal("OK"))
+			Expect(conn2.Select(ctx, 2).Val()).To(Equal("OK"))
+			Expect(conn2.Get(ctx, "key").Val()).To(Equal("value2"))
+			Expect(conn1.Get(ctx, "key").Val()).To(Equal("value1"))
+			Expect(conn1.Ping(ctx).Err()).To(HaveOccurred())
+		})
+
+		It("dial order", func() {
+			tenant1 := clientMock.ExpectConn()
+			tenant1.ExpectSelect(1).SetVal("OK")
+			tenant2 := clientMock.ExpectConn()
+			tenant2.ExpectSelect(2).SetErr(errors.New("ERR DB index is out of range"))
+
+			conn1 := client.Conn()
+			defer conn1.Close()
+			Expect(conn1.Select(ctx, 1).Err()).NotTo(HaveOccurred())
+
+			conn2 := client.Conn()
+			defer conn2.Close()
+			Expect(conn2.Select(ctx, 2).Err()).To(MatchError("ERR DB index is out of range"))
+		})
+
+		It("unmet expectations of a connection", func() {
+			clientMock.ExpectConn().ExpectSelect(1).SetVal("OK")
+			Expect(clientMock.ExpectationsWereMet()).To(HaveOccurred())
+
+			// let AfterEach pass
+			clientMock.ClearExpect()
+		})
+
+		It("stateful commands", func() {
+			conn := clientMock.ExpectConn()
+			conn.ExpectAuthACL("user", "pass").SetVal("OK")
+			conn.ExpectClientSetName("worker").SetVal(true)
+			conn.ExpectSwapDB(0, 1).SetVal("OK")
+			conn.ExpectHello(3, "", "pass", "").SetVal(map[string]interface{}{"proto": int64(3)})
+			conn.ExpectAuth("wrong").SetErr(errors.New("WRONGPASS invalid username-password pair"))
+
+			c := client.Conn()
+			defer c.Close()
+
+			Expect(c.AuthACL(ctx, "user", "pass").Val()).To(Equal("OK"))
+			Expect(c.ClientSetName(ctx, "worker").Val()).To(BeTrue())
+			Expect(c.SwapDB(ctx, 0, 1).Val()).To(Equal("OK"))
+			Expect(c.Hello(ctx, 3, "", "pass", "").Val()).To(Equal(map[string]interface{}{"proto": int64(3)}))
+			Expect(c.Auth(ctx, "wrong").Err()).To(MatchError("WRONGPASS invalid username-password pair"))
+		})
+
+		It("replies", func() {
+			conn := clientMock.ExpectConn()
+			conn.ExpectTTL("key").SetVal(-1)
+			conn.ExpectPTTL("key").SetVal(1500 * time.Millisecond)
+			conn.ExpectGet("missing").RedisNil()
+			conn.ExpectHGetAll("hash").SetVal(map[string]string{"a": "1"})
+			conn.ExpectScan(0, "*", 10).SetVal([]string{"key"}, 12)
+			conn.ExpectZRangeWithScores("zset", 0, -1).SetVal([]redis.Z{{Member: "m", Score: 1.5}})
+
+			c := client.Conn()
+			defer c.Close()
+
+			Expect(c.TTL(ctx, "key").Val()).To(Equal(time.Duration(-1)))
+			Expect(c.PTTL(ctx, "key").Val()).To(Equal(1500 * time.Millisecond))
+			Expect(c.Get(ctx, "missing").Err()).To(Equal(redis.Nil))
+			Expect(c.HGetAll(ctx, "hash").Val()).To(Equal(map[string]string{"a": "1"}))
+			keys, cursor := c.Scan(ctx, 0, "*", 10).Val()
+			Expect(keys).To(Equal([]string{"key"}))
+			Expect(cursor).To(Equal(uint64(12)))
+			Expect(c.ZRangeWithScores(ctx, "zset", 0, -1).Val()).To(Equal([]redis.Z{{Member: "m", Score: 1.5}}))
+		})
+
+		It("pipelined", func() {
+			conn := clientMock.ExpectConn()
+			conn.ExpectSelect(1).SetVal("OK")
+			conn.ExpectIncr("key").SetVal(2)
+
+			c := client.Conn()
+			defer c.Close()
+
+			cmds, err := c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Select(ctx, 1)
+				pipe.Incr(ctx, "key")
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cmds[1].(*redis.IntCmd).Val()).To(Equal(int64(2)))
+		})
+
+		It("watch per connection", func() {
+			clientMock.ExpectWatch("key")
+			clientMock.ExpectWatch("other")
+			clientMock.ExpectTxPipeline()
+			clientMock.ExpectGet("key").SetVal("v")
+			clientMock.ExpectTxPipelineExec()
+			clientMock.ExpectTxPipeline()
+			clientMock.ExpectSet("other", "1", 0).SetVal("OK")
+			clientMock.ExpectTxPipelineExec()
+
+			c1 := client.Conn()
+			defer c1.Close()
+			c2 := client.Conn()
+			defer c2.Close()
+
+			Expect(c1.Process(ctx, redis.NewStatusCmd(ctx, "watch", "key"))).NotTo(HaveOccurred())
+			Expect(c2.Process(ctx, redis.NewStatusCmd(ctx, "watch", "other"))).NotTo(HaveOccurred())
+			clientMock.ModifyWatchedKeys("other")
+
+			cmds, err := c1.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Get(ctx, "key")
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cmds[0].(*redis.StringCmd).Val()).To(Equal("v"))
+
+			_, err = c2.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, "other", "1", 0)
+				return nil
+			})
+			Expect(err).To(Equal(redis.TxFailedErr))
+		})
+
+		It("tx pipelined", func() {
+			conn := clientMock.ExpectConn()
+			conn.ExpectTxPipeline()
+			conn.ExpectSelect(1).SetVal("OK")
+			conn.ExpectSet("key", "value", 0).SetVal("OK")
+			conn.ExpectTxPipelineExec()
+
+			c := client.Conn()
+			defer c.Close()
+
+			cmds, err := c.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Select(ctx, 1)
+				pipe.Set(ctx, "key", "value", 0)
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cmds[1].(*redis.StatusCmd).Val()).To(Equal("OK"))
+		})
+
+		It("pipeline select", func() {
+			clientMock.ExpectSelect(1).SetVal("OK")
+			clientMock.ExpectGet("key").SetVal("value")
+
+			cmds, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Select(ctx, 1)
+				pipe.Get(ctx, "key")
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cmds[1].(*redis.StringCmd).Val()).To(Equal("value"))
+		})
+	})
+
+	Describe("handshake", func() {
+		var connected int
+
+		BeforeEach(func() {
+			connected = 0
+			client, clientMock = NewClientMockWithOptions(&redis.Options{
+				Username:   "user",
+				Password:   "pass",
+				DB:         2,
+				ClientName: "worker",
+				OnConnect: func(ctx context.Context, cn *redis.Conn) error {
+					connected++
+					return cn.Ping(ctx).Err()
+				},
+			})
+		})
+
+		It("init before the first command", func() {
+			clientMock.ExpectHello(3, "user", "pass", "").SetVal(map[string]interface{}{"proto": int64(3)})
+			clientMock.ExpectSelect(2).SetVal("OK")
+			clientMock.ExpectClientSetName("worker").SetVal(true)
+			clientMock.ExpectPing().SetVal("PONG")
+			clientMock.ExpectGet("key").SetVal("value")
+			clientMock.ExpectSet("key", "value", 0).SetVal("OK")
+
+			Expect(client.Get(ctx, "key").Val()).To(Equal("value"))
+			Expect(client.Set(ctx, "key", "value", 0).Val()).To(Equal("OK"))
+			Expect(connected).To(Equal(1))
+		})
+
+		It("wrong password", func() {
+			clientMock.ExpectHello(3, "user", "pass", "").SetErr(errors.New("WRONGPASS invalid username-password pair or user is disabled."))
+
+			Expect(client.Get(ctx, "key").Err()).To(MatchError("WRONGPASS invalid username-password pair or user is disabled."))
+			Expect(connected).To(Equal(0))
+
+			// the next command initialises the connection again
+			clientMock.ExpectHello(3, "user", "pass", "").SetVal(map[string]interface{}{})
+			clientMock.ExpectSelect(2).SetVal("OK")
+			clientMock.ExpectClientSetName("worker").SetVal(true)
+			clientMock.ExpectPing().SetVal("PONG")
+			clientMock.ExpectGet("key").SetVal("value")
+
+			Expect(client.Get(ctx, "key").Val()).To(Equal("value"))
+			Expect(connected).To(Equal(1))
+		})
+
+		It("auth fallback", func() {
+			clientMock.ExpectHello(3, "user", "pass", "").SetErr(errors.New("NOAUTH Authentication required."))
+			clientMock.ExpectAuthACL("user", "pass").SetErr(errors.New("WRONGPASS invalid username-password pair or user is disabled."))
+			clientMock.ExpectSelect(2).SetErr(errors.New("NOAUTH Authentication required."))
+			clientMock.ExpectClientSetName("worker").SetErr(errors.New("NOAUTH Authentication required."))
+
+			_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Get(ctx, "key")
+				return nil
+			})
+			Expect(err).To(MatchError("WRONGPASS invalid username-password pair or user is disabled."))
+			Expect(connected).To(Equal(0))
+		})
+
+		It("scoped to the dialed connection", func() {
+			conn := clientMock.ExpectConn()
+			conn.ExpectHello(3, "user", "pass", "").SetVal(map[string]interface{}{})
+			conn.ExpectSelect(2).SetVal("OK")
+			conn.ExpectClientSetName("worker").SetVal(true)
+			conn.ExpectPing().SetVal("PONG")
+			conn.ExpectGet("key").SetVal("value")
+
+			c := client.Conn()
+			defer c.Close()
+
+			Expect(c.Get(ctx, "key").Val()).To(Equal("value"))
+			Expect(connected).To(Equal(1))
+		})
+	})
+
+	Describe("functions", func() {
+		lib := "#!lua name=mylib\n" +
+			"redis.register_function('myfunc', function(keys, args) return args[1] end)\n"
+
+		It("function not found", func() {
+			clientMock.ExpectFCall("myfunc", []string{"key"}, "arg").SetVal("arg")
+
+			err := client.Do(ctx, "fcall", "myfunc", 1, "key", "arg").Err()
+			Expect(err).To(MatchError("ERR Function not found"))
+		})
+
+		It("library lifecycle", func() {
+			clientMock.ExpectFunctionLoad(lib).SetVal("mylib")
+			clientMock.ExpectFCall("myfunc", []string{"key"}, "arg").SetVal("arg")
+			clientMock.ExpectFunctionDelete("mylib").SetVal("OK")
+			clientMock.ExpectFCallRO("myfunc", nil, "arg").SetVal("arg")
+
+			Expect(client.FunctionLoad(ctx, lib).Val()).To(Equal("mylib"))
+			Expect(client.Do(ctx, "fcall", "myfunc", 1, "key", "arg").Val()).To(Equal("arg"))
+			Expect(client.FunctionDelete(ctx, "mylib").Val()).To(Equal("OK"))
+
+			err := client.Do(ctx, "fcall_ro", "myfunc", 0, "arg").Err()
+			Expect(err).To(MatchError("ERR Function not found"))
+		})
+
+		It("flush", func() {
+			Expect(clientMock.PreloadFunctions(lib)).NotTo(HaveOccurred())
+			clientMock.ExpectFCall("myfunc", nil).SetVal("ok")
+			clientMock.ExpectFunctionFlush().SetVal("OK")
+			clientMock.ExpectFCall("myfunc", nil).SetVal("ok")
+
+			Expect(client.Do(ctx, "fcall", "myfunc", 0).Val()).To(Equal("ok"))
+			Expect(client.FunctionFlush(ctx).Val()).To(Equal("OK"))
+			Expect(client.Do(ctx, "fcall", "myfunc", 0).Err()).To(MatchError("ERR Function not found"))
+		})
+
+		It("invalid library", func() {
+			Expect(clientMock.PreloadFunctions("return 1")).To(MatchError("ERR Missing library metadata"))
+		})
+
+		It("executed after a matched load", func() {
+			clientMock.ExpectFunctionLoad(lib).SetVal("mylib")
+			Expect(client.FunctionLoad(ctx, lib).Val()).To(Equal("mylib"))
+
+			clientMock.ExecuteScripts(NewKeyspace())
+			Expect(client.Do(ctx, "fcall", "myfunc", 0, "arg").Val()).To(Equal("arg"))
+		})
+
+		It("function kill", func() {
+			clientMock.ExpectFunctionKill().SetVal("OK")
+			Expect(client.Do(ctx, "function", "kill").Val()).To(Equal("OK"))
+		})
+	})
+
+	Describe("execute scripts", func() {
+		var ks *Keyspace
+
+		BeforeEach(func() {
+			ks = NewKeyspace()
+			clientMock.ExecuteScripts(ks)
+		})
+
+		It("rate limiter", func() {
+			limiter := redis.NewScript(`
+				local n = redis.call("INCR", KEYS[1])
+				if n == 1 then
+					redis.call("EXPIRE", KEYS[1], ARGV[2])
+				end
+				if n > tonumber(ARGV[1]) then
+					return 0
+				end
+				return 1
+			`)
+
+			for i := 0; i < 3; i++ {
+				val, err := limiter.Run(ctx, client, []string{"limit:user"}, 2, 60).Int()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(val).To(Equal(map[bool]int{true: 1, false: 0}[i < 2]))
+			}
+
+			ttl, err := ks.Do("ttl", "limit:user")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ttl).To(Equal(int64(60)))
+		})
+
+		It("lock release", func() {
+			release := redis.NewScript(`
+				if redis.call("GET", KEYS[1]) == ARGV[1] then
+					return redis.call("DEL", KEYS[1])
+				end
+				return 0
+			`)
+			_, err := ks.Do("set", "lock", "token-1")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(release.Run(ctx, client, []string{"lock"}, "token-2").Int()).To(Equal(0))
+			Expect(release.Run(ctx, client, []string{"lock"}, "token-1").Int()).To(Equal(1))
+
+			_, err = ks.Do("get", "lock")
+			Expect(err).To(BeNil())
+			Expect(ks.Do("exists", "lock")).To(Equal(int64(0)))
+		})
+
+		It("reply conversion", func() {
+			val, err := client.Eval(ctx, `return {1, "two", 3.9, redis.status_reply("OK"), false, nil, "unreachable"}`, nil).Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(val).To(Equal([]interface{}{int64(1), "two", int64(3), "OK", nil}))
+
+			err = client.Eval(ctx, `return redis.call("GET", KEYS[1])`, []string{"missing"}).Err()
+			Expect(err).To(Equal(redis.Nil))
+
+			err = client.Eval(ctx, `return redis.error_reply("BUSY try later")`, nil).Err()
+			Expect(redis.HasErrorPrefix(err, "BUSY")).To(BeTrue())
+		})
+
+		It("number arguments", func() {
+			err := client.Eval(ctx, `redis.call("SET", KEYS[1], 0.1) return redis.call("INCRBY", KEYS[2], 3)`, []string{"f", "n"}).Err()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ks.Do("get", "f")).To(Equal("0.1"))
+			Expect(ks.Do("get", "n")).To(Equal("3"))
+		})
+
+		It("call and pcall errors", func() {
+			_, err := ks.Do("hset", "hash", "field", "value")
+			Expect(err).NotTo(HaveOccurred())
+
+			err = client.Eval(ctx, `return redis.call("GET", KEYS[1])`, []string{"hash"}).Err()
+			Expect(redis.HasErrorPrefix(err, "WRONGTYPE")).To(BeTrue())
+
+			val, err := client.Eval(ctx, `
+				local reply = redis.pcall("GET", KEYS[1])
+				return reply["err"]
+			`, []string{"hash"}).Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(val).To(HavePrefix("WRONGTYPE"))
+
+			err = client.Eval(ctx, `return undefined_fn()`, nil).Err()
+			Expect(err).To(MatchError(HavePrefix("ERR user_script:1:")))
+		})
+
+		It("read only", func() {
+			err := client.EvalRO(ctx, `return redis.call("SET", KEYS[1], "v")`, []string{"key"}).Err()
+			Expect(err).To(MatchError("ERR Write commands are not allowed from read-only scripts."))
+			Expect(ks.Do("exists", "key")).To(Equal(int64(0)))
+		})
+
+		It("script cache", func() {
+			sha, err := client.ScriptLoad(ctx, `return ARGV[1]`).Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(client.ScriptExists(ctx, sha, "0000").Val()).To(Equal([]bool{true, false}))
+			Expect(client.EvalSha(ctx, sha, nil, "hello").Val()).To(Equal("hello"))
+
+			Expect(client.ScriptFlush(ctx).Val()).To(Equal("OK"))
+			err = client.EvalSha(ctx, sha, nil, "hello").Err()
+			Expect(redis.HasErrorPrefix(err, "NOSCRIPT")).To(BeTrue())
+		})
+
+		It("fcall", func() {
+			lib := "#!lua name=counters\n" +
+				"redis.register_function('incr_by', function(keys, args) return redis.call('INCRBY', keys[1], args[1]) end)\n" +
+				"redis.register_function{function_name='peek', callback=function(keys) return redis.call('GET', keys[1]) end, flags={'no-writes'}}\n"
+
+			Expect(client.FunctionLoad(ctx, lib).Val()).To(Equal("counters"))
+			Expect(client.FunctionLoad(ctx, lib).Err()).To(MatchError("ERR Library 'counters' already exists"))
+
+			Expect(client.Do(ctx, "fcall", "incr_by", 1, "counter", 5).Val()).To(Equal(int64(5)))
+			Expect(client.Do(ctx, "fcall_ro", "peek", 1, "counter").Val()).To(Equal("5"))
+
+			err := client.Do(ctx, "fcall_ro", "incr_by", 1, "counter", 5).Err()
+			Expect(err).To(MatchError("ERR Can not execute a script with write flag using *_ro command."))
+
+			Expect(client.FunctionDelete(ctx, "counters").Val()).To(Equal("OK"))
+			err = client.Do(ctx, "fcall", "incr_by", 1, "counter", 5).Err()
+			Expect(err).To(MatchError("ERR Function not found"))
+		})
+
+		It("preloaded functions", func() {
+			lib := "#!lua name=echo\nredis.register_function('echo', function(keys, args) return args[1] end)\n"
+			Expect(clientMock.PreloadFunctions(lib)).NotTo(HaveOccurred())
+
+			Expect(client.Do(ctx, "fcall", "echo", 0, "hello").Val()).To(Equal("hello"))
+			Expect(client.FunctionLoad(ctx, lib).Err()).To(MatchError("ERR Library 'echo' already exists"))
+
+			Expect(client.FunctionFlush(ctx).Val()).To(Equal("OK"))
+			Expect(clientMock.PreloadFunctions(lib)).NotTo(HaveOccurred())
+			Expect(client.FunctionDelete(ctx, "echo").Val()).To(Equal("OK"))
+			Expect(client.Do(ctx, "fcall", "echo", 0, "hello").Err()).To(MatchError("ERR Function not found"))
+		})
+
+		It("other commands use expectations", func() {
+			clientMock.ExpectGet("key").SetVal("value")
+
+			Expect(client.Get(ctx, "key").Val()).To(Equal("value"))
+			Expect(client.Eval(ctx, `return 1`, nil).Val()).To(Equal(int64(1)))
+		})
+	})
+
 	Describe("work order", func() {
 
 		BeforeEach(func() {
@@ -301,7 +1224,7 @@ var _ = Describe("Client", func() {
 			}).ExpectGet("key").SetVal("OK")
 
 			get := client.Get(ctx, "key")
-			Expect(get.Err()).To(Equal(errors.New("mismatch")))
+			Expect(get.Err()).To(MatchError("mismatch"))
 			Expect(get.Val()).To(Equal(""))
 
 			set := client.Incr(ctx, "key")
@@ -345,4 +1268,457 @@ var _ = Describe("Client", func() {
 		})
 
 	})
+
+	Describe("wire args", func() {
+		It("same bytes on the wire", func() {
+			at := time.Date(2023, 3, 29, 13, 41, 6, 0, time.UTC)
+			clientMock.ExpectSet("k", "1", 0).SetVal("OK")
+			clientMock.ExpectSet("k", []byte("v"), 0).SetVal("OK")
+			clientMock.ExpectHSet("h", "f", 1.5, "b", true).SetVal(2)
+			clientMock.ExpectSet("k", "2023-03-29T13:41:06Z", 0).SetVal("OK")
+			clientMock.ExpectSet("k", wireValue("v"), 0).SetVal("OK")
+
+			Expect(client.Set(ctx, "k", 1, 0).Err()).NotTo(HaveOccurred())
+			Expect(client.Set(ctx, "k", "v", 0).Err()).NotTo(HaveOccurred())
+			Expect(client.HSet(ctx, "h", "f", "1.5", "b", 1).Err()).NotTo(HaveOccurred())
+			Expect(client.Set(ctx, "k", at, 0).Err()).NotTo(HaveOccurred())
+			Expect(client.Set(ctx, "k", []byte("v"), 0).Err()).NotTo(HaveOccurred())
+		})
+
+		It("mismatch shows the wire forms", func() {
+			clientMock.ExpectSet("k", 1, 0).SetVal("OK")
+
+			Expect(client.Set(ctx, "k", 1.5, 0).Err()).To(MatchError(
+				`args not match, expectation: '1' (int, wire "1"), but gave: '1.5' (float64, wire "1.5")`))
+
+			// let AfterEach pass
+			clientMock.ClearExpect()
+		})
+
+		It("regexp on the fmt.Sprint form", func() {
+			clientMock.Regexp().ExpectSet("k", `^true$`, 0).SetVal("OK")
+			clientMock.Regexp().ExpectSet("k", `^1$`, 0).SetVal("OK")
+
+			Expect(client.Set(ctx, "k", true, 0).Err()).NotTo(HaveOccurred())
+			Expect(client.Set(ctx, "k", true, 0).Err()).To(MatchError(
+				"args not match, expectation regular: '^1$', but gave: 'true'"))
+
+			// let AfterEach pass
+			clientMock.ClearExpect()
+		})
+	})
+
+	Describe("unordered args", func() {
+		It("set-like arguments", func() {
+			clientMock.ExpectSAdd("s", "a", "b", "c").SetVal(3)
+			clientMock.ExpectDel("k1", "k2").SetVal(2)
+			clientMock.ExpectHDel("h", "f1", "f2").SetVal(2)
+			clientMock.ExpectZAdd("z", redis.Z{Score: 1, Member: "a"}, redis.Z{Score: 2, Member: "b"}).SetVal(2)
+			clientMock.ExpectXAdd(&redis.XAddArgs{Stream: "x", ID: "1-0", Values: []interface{}{"f1", "v1", "f2", "v2"}}).SetVal("1-0")
+
+			Expect(client.SAdd(ctx, "s", "c", "a", "b").Val()).To(Equal(int64(3)))
+			Expect(client.Del(ctx, "k2", "k1").Val()).To(Equal(int64(2)))
+			Expect(client.HDel(ctx, "h", "f2", "f1").Val()).To(Equal(int64(2)))
+			Expect(client.ZAdd(ctx, "z", redis.Z{Score: 2, Member: "b"}, redis.Z{Score: 1, Member: "a"}).Val()).To(Equal(int64(2)))
+			Expect(client.XAdd(ctx, &redis.XAddArgs{Stream: "x", ID: "1-0", Values: []interface{}{"f2", "v2", "f1", "v1"}}).Val()).To(Equal("1-0"))
+		})
+
+		It("pairs stay together", func() {
+			clientMock.ExpectZAdd("z", redis.Z{Score: 1, Member: "a"}, redis.Z{Score: 2, Member: "b"}).SetVal(2)
+
+			Expect(client.ZAdd(ctx, "z", redis.Z{Score: 2, Member: "a"}, redis.Z{Score: 1, Member: "b"}).Err()).To(MatchError(
+				"args not match in any order, expectation: '[1 a 2 b]', but gave: '[2 a 1 b]'"))
+
+			// let AfterEach pass
+			clientMock.ClearExpect()
+		})
+
+		It("scripts read KEYS and ARGV by position", func() {
+			clientMock.ExpectEval("return 1", []string{"k1", "k2"}, "a", "b").SetVal(int64(1))
+
+			Expect(client.Eval(ctx, "return 1", []string{"k2", "k1"}, "a", "b").Err()).To(HaveOccurred())
+			Expect(client.Eval(ctx, "return 1", []string{"k1", "k2"}, "b", "a").Err()).To(HaveOccurred())
+
+			// let AfterEach pass
+			clientMock.ClearExpect()
+		})
+
+		It("hmget replies in the order of the call", func() {
+			clientMock.ExpectHMGet("h", "f1", "f2", "f3").SetVal([]interface{}{"v1", "v2", nil})
+
+			Expect(client.HMGet(ctx, "h", "f3", "f1", "f2").Val()).To(Equal([]interface{}{nil, "v1", "v2"}))
+		})
+	})
+
+	Describe("context", func() {
+		type tenantKey struct{}
+
+		It("with context", func() {
+			e := clientMock.ExpectGet("k")
+			e.WithContext(HasValue(tenantKey{}, "acme"))
+			e.WithContext(HasDeadlineWithin(time.Second))
+			e.SetVal("v")
+
+			Expect(client.Get(ctx, "k").Err()).To(MatchError(
+				"context not match, call to cmd '[get k]': context value of '{}' is '<nil>', expectation 'acme'"))
+
+			tenantCtx := context.WithValue(ctx, tenantKey{}, "acme")
+			Expect(client.Get(tenantCtx, "k").Err()).To(MatchError(
+				"context not match, call to cmd '[get k]': context has no deadline, expectation deadline within 1s"))
+
+			deadlineCtx, cancel := context.WithTimeout(tenantCtx, time.Minute)
+			defer cancel()
+			Expect(client.Get(deadlineCtx, "k").Err()).To(HaveOccurred())
+
+			timeoutCtx, cancel := context.WithTimeout(tenantCtx, 500*time.Millisecond)
+			defer cancel()
+			Expect(client.Get(timeoutCtx, "k").Val()).To(Equal("v"))
+		})
+
+		It("unordered expectations by context", func() {
+			clientMock.MatchExpectationsInOrder(false)
+			first := clientMock.ExpectGet("k")
+			first.WithContext(HasValue(tenantKey{}, "a"))
+			first.SetVal("a")
+			second := clientMock.ExpectGet("k")
+			second.WithContext(HasValue(tenantKey{}, "b"))
+			second.SetVal("b")
+
+			Expect(client.Get(context.WithValue(ctx, tenantKey{}, "b"), "k").Val()).To(Equal("b"))
+			Expect(client.Get(context.WithValue(ctx, tenantKey{}, "a"), "k").Val()).To(Equal("a"))
+		})
+
+		It("call log", func() {
+			clientMock.ExpectSet("k", "v", 0).SetVal("OK")
+			clientMock.ExpectGet("k").SetVal("v")
+
+			tenantCtx := context.WithValue(ctx, tenantKey{}, "acme")
+			Expect(client.Set(tenantCtx, "k", "v", 0).Err()).NotTo(HaveOccurred())
+			Expect(client.Get(ctx, "k").Err()).NotTo(HaveOccurred())
+
+			calls := clientMock.Calls()
+			Expect(calls).To(HaveLen(2))
+			Expect(calls[0].Cmd.Args()).To(Equal([]interface{}{"set", "k", "v"}))
+			Expect(calls[0].Ctx.Value(tenantKey{})).To(Equal("acme"))
+			Expect(calls[1].Cmd.Args()).To(Equal([]interface{}{"get", "k"}))
+			Expect(calls[1].Ctx.Value(tenantKey{})).To(BeNil())
+		})
+
+		It("conn", func() {
+			conn := clientMock.ExpectConn()
+			get := conn.ExpectGet("k")
+			get.WithContext(HasValue(tenantKey{}, "acme"))
+			get.SetVal("v")
+			gated := conn.ExpectGet("gated")
+			gated.SetVal("v")
+			gate := gated.Gate()
+
+			c := client.Conn()
+			c.AddHook(clientMock.ConnHook())
+			defer c.Close()
+
+			Expect(c.Get(ctx, "k").Err()).To(MatchError(
+				"context not match, call to cmd '[get k]': context value of '{}' is '<nil>', expectation 'acme'"))
+			tenantCtx := context.WithValue(ctx, tenantKey{}, "acme")
+			Expect(c.Get(tenantCtx, "k").Val()).To(Equal("v"))
+
+			calls := clientMock.Calls()
+			Expect(calls[len(calls)-1].Ctx.Value(tenantKey{})).To(Equal("acme"))
+
+			cancelCtx, cancel := context.WithCancel(ctx)
+			go func() {
+				<-gate.Arrived()
+				cancel()
+			}()
+			Expect(c.Get(cancelCtx, "gated").Err()).To(MatchError(context.Canceled.Error()))
+		})
+	})
+
+	Describe("wait for expectations", func() {
+		It("asynchronous worker", func() {
+			get := clientMock.ExpectGet("k")
+			get.SetVal("v")
+			clientMock.ExpectSet("k", "w", 0).SetVal("OK")
+
+			go func() {
+				defer GinkgoRecover()
+				time.Sleep(10 * time.Millisecond)
+				Expect(client.Get(ctx, "k").Val()).To(Equal("v"))
+				Expect(client.Set(ctx, "k", "w", 0).Err()).NotTo(HaveOccurred())
+			}()
+
+			Eventually(get.Done()).Should(BeClosed())
+			Expect(clientMock.WaitForExpectationsWithTimeout(time.Second)).NotTo(HaveOccurred())
+		})
+
+		It("timeout", func() {
+			clientMock.ExpectGet("k").SetVal("v")
+
+			err := clientMock.WaitForExpectationsWithTimeout(10 * time.Millisecond)
+			Expect(err.Error()).To(HavePrefix("there is a remaining expectation which was not matched: [get k]"))
+			Expect(err.Error()).To(HaveSuffix("(context deadline exceeded)"))
+
+			// let AfterEach pass
+			clientMock.ClearExpect()
+		})
+
+		It("done", func() {
+			e := clientMock.ExpectGet("k")
+			e.SetVal("v")
+			Expect(e.Done()).NotTo(BeClosed())
+
+			Expect(client.Get(ctx, "k").Val()).To(Equal("v"))
+			Expect(e.Done()).To(BeClosed())
+		})
+	})
+
+	Describe("gate", func() {
+		It("lost update", func() {
+			getA := clientMock.ExpectGet("counter")
+			getA.SetVal("0")
+			gateA := getA.Gate()
+			getB := clientMock.ExpectGet("counter")
+			getB.SetVal("0")
+			gateB := getB.Gate()
+			clientMock.ExpectSet("counter", "1", 0).SetVal("OK")
+			clientMock.ExpectSet("counter", "1", 0).SetVal("OK")
+
+			incr := func(done chan<- string) {
+				defer GinkgoRecover()
+				n, err := client.Get(ctx, "counter").Int()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(client.Set(ctx, "counter", n+1, 0).Err()).NotTo(HaveOccurred())
+				done <- strconv.Itoa(n + 1)
+			}
+
+			// A's GET, B's GET, A's SET, B's SET
+			doneA, doneB := make(chan string), make(chan string)
+			go incr(doneA)
+			Eventually(gateA.Arrived()).Should(BeClosed())
+			go incr(doneB)
+			Eventually(gateB.Arrived()).Should(BeClosed())
+
+			gateA.Release()
+			Eventually(doneA).Should(Receive(Equal("1")))
+			gateB.Release()
+			Eventually(doneB).Should(Receive(Equal("1")))
+		})
+
+		It("block until", func() {
+			ch := make(chan struct{})
+			e := clientMock.ExpectGet("k")
+			e.SetVal("v")
+			gate := e.BlockUntil(ch)
+
+			go func() {
+				<-gate.Arrived()
+				close(ch)
+			}()
+			Expect(client.Get(ctx, "k").Val()).To(Equal("v"))
+		})
+
+		It("released before the command", func() {
+			e := clientMock.ExpectGet("k")
+			e.SetVal("v")
+			e.Gate().Release()
+
+			Expect(client.Get(ctx, "k").Val()).To(Equal("v"))
+		})
+
+		It("context canceled", func() {
+			e := clientMock.ExpectGet("k")
+			e.SetVal("v")
+			gate := e.Gate()
+
+			timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+			defer cancel()
+			Expect(client.Get(timeoutCtx, "k").Err()).To(Equal(context.DeadlineExceeded))
+			Expect(gate.Arrived()).To(BeClosed())
+		})
+	})
+
+	Describe("errors", func() {
+		It("unmet expectations", func() {
+			clientMock.ExpectGet("a").SetVal("1")
+			clientMock.ExpectSet("b", "2", 0).SetVal("OK")
+			clientMock.ExpectDel("c").SetVal(1)
+
+			Expect(client.Get(ctx, "a").Val()).To(Equal("1"))
+
+			var unmet *UnmetExpectationsError
+			err := clientMock.Regexp().ExpectationsWereMet()
+			Expect(errors.As(err, &unmet)).To(BeTrue())
+			Expect(unmet.Expectations).To(HaveLen(2))
+			Expect(unmet.Expectations[0].Cmd).To(Equal("set"))
+			Expect(unmet.Expectations[0].Args).To(Equal([]interface{}{"set", "b", "2"}))
+			Expect(unmet.Expectations[0].Times).To(Equal(1))
+			Expect(unmet.Expectations[0].Calls).To(Equal(0))
+			Expect(unmet.Expectations[0].Site).To(MatchRegexp(`^client_test\.go:\d+$`))
+			Expect(unmet.Expectations[1].Cmd).To(Equal("del"))
+			Expect(err.Error()).To(HavePrefix("there are 2 remaining expectations which were not matched:\n\t[set b 2] (called 0 of 1 times"))
+
+			// let AfterEach pass
+			clientMock.ClearExpect()
+		})
+
+		It("unexpected call", func() {
+			clientMock.ExpectGet("a").SetVal("1")
+
+			var unexpected *UnexpectedCallError
+			err := client.Get(ctx, "b").Err()
+			Expect(errors.As(err, &unexpected)).To(BeTrue())
+			Expect(unexpected.Cmd.Args()).To(Equal([]interface{}{"get", "b"}))
+			Expect(unexpected.Err).To(MatchError(`args not match, expectation: 'a' (string, wire "a"), but gave: 'b' (string, wire "b")`))
+
+			Expect(client.Get(ctx, "a").Val()).To(Equal("1"))
+			err = client.Get(ctx, "a").Err()
+			Expect(errors.As(err, &unexpected)).To(BeTrue())
+			Expect(unexpected.Fulfilled).To(BeTrue())
+			Expect(err).To(MatchError("all expectations were already fulfilled, call to cmd '[get a]' was not expected"))
+		})
+
+		It("derived mock", func() {
+			clientMock.MatchExpectationsInOrder(false)
+			clientMock.ExpectGet("a").SetVal("1")
+			clientMock.CustomMatch(func(expected, actual []interface{}) error {
+				return nil
+			}).ExpectGet("b").SetVal("2")
+
+			Expect(client.Get(ctx, "b").Val()).To(Equal("2"))
+			Expect(client.Get(ctx, "a").Val()).To(Equal("1"))
+			Expect(clientMock.Regexp().ExpectationsWereMet()).NotTo(HaveOccurred())
+		})
+	})
+
+	Describe("scopes", func() {
+		It("combined options", func() {
+			scope := clientMock.With(Regexp, Times(2), Named("refresh"))
+			scope.ExpectGet(`^user:\d+$`).SetVal("alice")
+
+			Expect(client.Get(ctx, "user:1").Val()).To(Equal("alice"))
+			err := clientMock.ExpectationsWereMet()
+			Expect(err.Error()).To(HavePrefix("there is a remaining expectation which was not matched: refresh [get ^user:\\d+$] (called 1 of 2 times"))
+
+			Expect(client.Get(ctx, "user:2").Val()).To(Equal("alice"))
+			Expect(client.Get(ctx, "user:3").Err()).To(HaveOccurred())
+		})
+
+		It("nested scopes", func() {
+			scope := clientMock.With(Named("outer")).Regexp()
+			scope.With(Times(2)).ExpectGet("^a").SetVal("1")
+			scope.ExpectGet("^b").SetVal("2")
+
+			var unmet *UnmetExpectationsError
+			Expect(errors.As(scope.ExpectationsWereMet(), &unmet)).To(BeTrue())
+			Expect(unmet.Expectations).To(HaveLen(2))
+			Expect(unmet.Expectations[0].Name).To(Equal("outer"))
+			Expect(unmet.Expectations[0].Times).To(Equal(2))
+			Expect(unmet.Expectations[1].Times).To(Equal(1))
+
+			Expect(client.Get(ctx, "a1").Val()).To(Equal("1"))
+			Expect(client.Get(ctx, "a2").Val()).To(Equal("1"))
+			Expect(client.Get(ctx, "b1").Val()).To(Equal("2"))
+		})
+
+		It("methods act on the mock", func() {
+			scope := clientMock.Regexp().With(Named("scope"))
+			scope.MatchExpectationsInOrder(false)
+			scope.ExpectGet("b").SetVal("2")
+			clientMock.ExpectGet("a").SetVal("1")
+
+			Expect(client.Get(ctx, "a").Val()).To(Equal("1"))
+			Expect(scope.ExpectationsWereMet()).To(HaveOccurred())
+			Expect(client.Get(ctx, "b").Val()).To(Equal("2"))
+			Expect(scope.ExpectationsWereMet()).NotTo(HaveOccurred())
+
+			scope.ExpectGet("c").SetVal("3")
+			scope.ClearExpect()
+			Expect(clientMock.ExpectationsWereMet()).NotTo(HaveOccurred())
+		})
+
+		It("times below one", func() {
+			Expect(func() { Times(0) }).To(PanicWith("redismock: Times(0), n must be at least 1"))
+		})
+
+		It("done after all calls", func() {
+			e := clientMock.With(Times(2)).ExpectGet("k")
+			e.SetVal("v")
+
+			Expect(client.Get(ctx, "k").Val()).To(Equal("v"))
+			Expect(e.Done()).NotTo(BeClosed())
+			Expect(client.Get(ctx, "k").Val()).To(Equal("v"))
+			Expect(e.Done()).To(BeClosed())
+		})
+	})
+
+	Describe("matcher", func() {
+		type tenantKey struct{}
+
+		tenantGet := MatcherFunc("get of the tenant key", func(ctx context.Context, cmd redis.Cmder) error {
+			tenant, _ := ctx.Value(tenantKey{}).(string)
+			if cmd.Name() != "get" || fmt.Sprint(cmd.Args()[1]) != tenant+":profile" {
+				return fmt.Errorf("cmd '%+v' is not a get of '%s:profile'", cmd.Args(), tenant)
+			}
+			return nil
+		})
+
+		It("whole command", func() {
+			clientMock.With(MatchWith(tenantGet)).ExpectGet("").SetVal("acme profile")
+			e := clientMock.ExpectGet("")
+			e.WithMatcher(tenantGet)
+			e.SetVal("other profile")
+
+			acme := context.WithValue(ctx, tenantKey{}, "acme")
+			Expect(client.Get(acme, "acme:profile").Val()).To(Equal("acme profile"))
+			Expect(client.Get(acme, "other:profile").Err()).To(MatchError(
+				"matcher 'get of the tenant key' does not match call to cmd '[get other:profile]': " +
+					"cmd '[get other:profile]' is not a get of 'acme:profile'"))
+
+			other := context.WithValue(ctx, tenantKey{}, "other")
+			Expect(client.Get(other, "other:profile").Val()).To(Equal("other profile"))
+		})
+
+		It("adapters", func() {
+			clientMock.With(MatchWith(RegexpMatcher("get", `^user:\d+$`))).ExpectGet("").SetVal("1")
+			clientMock.With(MatchWith(CustomMatcher(func(expected, actual []interface{}) error {
+				if len(actual) != 3 {
+					return errors.New("not a set of a value")
+				}
+				return nil
+			}, "set", "", ""))).ExpectSet("", "", 0).SetVal("OK")
+
+			Expect(client.Get(ctx, "user:1").Val()).To(Equal("1"))
+			Expect(client.Set(ctx, "user:1", "alice", 0).Val()).To(Equal("OK"))
+		})
+
+		It("another reply type", func() {
+			e := clientMock.ExpectGet("k")
+			e.WithMatcher(MatcherFunc("any", func(ctx context.Context, cmd redis.Cmder) error {
+				return nil
+			}))
+			e.SetVal("v")
+
+			Expect(client.Incr(ctx, "k").Err()).To(MatchError(
+				"cmd type not match, expectation '*redis.StringCmd', but call to cmd '[incr k]' is '*redis.IntCmd'"))
+			Expect(client.Get(ctx, "k").Val()).To(Equal("v"))
+		})
+
+		It("unmet", func() {
+			clientMock.With(MatchWith(tenantGet)).ExpectGet("").SetVal("acme profile")
+
+			var unmet *UnmetExpectationsError
+			Expect(errors.As(clientMock.ExpectationsWereMet(), &unmet)).To(BeTrue())
+			Expect(unmet.Expectations[0].Matcher).To(Equal("get of the tenant key"))
+			Expect(unmet.Error()).To(ContainSubstring("[get ] matching 'get of the tenant key' (called 0 of 1 times"))
+
+			// let AfterEach pass
+			clientMock.ClearExpect()
+		})
+	})
 })
+
+type wireValue string
+
+func (v wireValue) MarshalBinary() ([]byte, error) {
+	return []byte(v), nil
+}
@@ -0,0 +1,836 @@
+package redismock
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const slotNumber = 16384
+
+// ClusterTopology describes the simulated cluster of NewClusterMockWithTopology.
+type ClusterTopology struct {
+	// Slots assigns slot ranges to nodes, the first node of a range is its master,
+	// the other nodes are its replicas. Nodes without ID get one derived from their address.
+	Slots []redis.ClusterSlot
+
+	// Options configures the ClusterClient, the mock sets Addrs, ClusterSlots and NewClient.
+	Options *redis.ClusterOptions
+}
+
+// NewClusterMockWithTopology creates a cluster mock whose ClusterClient routes commands to the
+// nodes of topology like it routes them to a real cluster. Every node answers CLUSTER SLOTS,
+// CLUSTER SHARDS and COMMAND itself, other commands are matched against the expectations of
+// the mock, expectations registered with Node only match commands served by that node.
+func NewClusterMockWithTopology(topology ClusterTopology) (*redis.ClusterClient, ClusterClientMock) {
+	m := newMock(redisCluster)
+	m.topology = newClusterTopology(topology.Slots)
+
+	var opt redis.ClusterOptions
+	if topology.Options != nil {
+		opt = *topology.Options
+	}
+	opt.Addrs = m.topology.addrs()
+	opt.ClusterSlots = nil
+	opt.NewClient = m.newNodeClient
+
+	client := redis.NewClusterClient(&opt)
+	m.client = client
+	m.topology.maxRedirects = client.Options().MaxRedirects
+	return client, m
+}
+
+// clusterTopology is the simulated cluster, shared by all clones of a mock.
+type clusterTopology struct {
+	mu    sync.RWMutex
+	slots []redis.ClusterSlot
+
+	// migrating maps the slots being migrated to the address of their target node
+	migrating map[int]string
+
+	moved, ask    int
+	replicaWrites int
+
+	// maxRedirects bounds the redirects the mock follows for a pipeline, like ClusterOptions.MaxRedirects
+	maxRedirects int
+
+	down   map[string]NodeFailure
+	served map[string]int
+}
+
+func newClusterTopology(slots []redis.ClusterSlot) *clusterTopology {
+	t := &clusterTopology{
+		slots:     make([]redis.ClusterSlot, len(slots)),
+		migrating: make(map[int]string),
+		down:      make(map[string]NodeFailure),
+		served:    make(map[string]int),
+	}
+	for i, slot := range slots {
+		nodes := make([]redis.ClusterNode, len(slot.Nodes))
+		for j, node := range slot.Nodes {
+			if node.ID == "" {
+				node.ID = nodeID(node.Addr)
+			}
+			nodes[j] = node
+		}
+		t.slots[i] = redis.ClusterSlot{Start: slot.Start, End: slot.End, Nodes: nodes}
+	}
+	return t
+}
+
+// NodeFailure is the way a node of a simulated cluster fails, see ClusterClientMock.NodeDown.
+type NodeFailure int
+
+const (
+	// NodeRefused fails commands like a node refusing connections.
+	NodeRefused NodeFailure = iota + 1
+	// NodeTimeout fails commands with a network timeout, without waiting for it.
+	NodeTimeout
+	// NodeClusterDown answers commands with CLUSTERDOWN, like a node of a cluster that lost a shard.
+	NodeClusterDown
+)
+
+func (f NodeFailure) error() error {
+	switch f {
+	case NodeRefused:
+		return &net.OpError{Op: "dial", Net: "tcp", Err: &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}}
+	case NodeTimeout:
+		return &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}
+	case NodeClusterDown:
+		return newRedisError("CLUSTERDOWN The cluster is down")
+	}
+	return fmt.Errorf("redismock: unknown node failure %d", f)
+}
+
+// nodeID derives a node ID of 40 hex characters from addr, like the IDs of redis-server.
+func nodeID(addr string) string {
+	sum := sha1.Sum([]byte(addr))
+	return hex.EncodeToString(sum[:])
+}
+
+// addrs returns the address of every node, masters first.
+func (t *clusterTopology) addrs() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var addrs []string
+	seen := make(map[string]bool)
+	for _, replicas := range []bool{false, true} {
+		for _, slot := range t.slots {
+			for i, node := range slot.Nodes {
+				if (i > 0) == replicas && !seen[node.Addr] {
+					seen[node.Addr] = true
+					addrs = append(addrs, node.Addr)
+				}
+			}
+		}
+	}
+	return addrs
+}
+
+// has reports whether addr is a node of the cluster.
+func (t *clusterTopology) has(addr string) bool {
+	for _, a := range t.addrs() {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// slotNodes returns the master and the replicas serving slot.
+func (t *clusterTopology) slotNodes(slot int) []redis.ClusterNode {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.slotNodesLocked(slot)
+}
+
+func (t *clusterTopology) slotNodesLocked(slot int) []redis.ClusterNode {
+	for _, s := range t.slots {
+		if slot >= s.Start && slot <= s.End {
+			return s.Nodes
+		}
+	}
+	return nil
+}
+
+// role returns roleReplica if addr is listed as a replica of a slot range, otherwise roleMaster.
+// A node that masters another range, or is the target of a migration, is a master.
+func (t *clusterTopology) role(addr string) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, target := range t.migrating {
+		if target == addr {
+			return roleMaster
+		}
+	}
+	role := roleMaster
+	for _, s := range t.slots {
+		for i, node := range s.Nodes {
+			if node.Addr != addr {
+				continue
+			}
+			if i == 0 {
+				return roleMaster
+			}
+			role = roleReplica
+		}
+	}
+	return role
+}
+
+// serves reports whether addr is the master or a replica of slot, or the target of its migration.
+func (t *clusterTopology) serves(addr string, slot int) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.migrating[slot] == addr {
+		return true
+	}
+	for _, node := range t.slotNodesLocked(slot) {
+		if node.Addr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// shardNodes returns the nodes of the shard whose master is addr, or addr alone
+// if it is not a master yet.
+func (t *clusterTopology) shardNodes(addr string) []redis.ClusterNode {
+	for _, s := range t.slots {
+		if len(s.Nodes) > 0 && s.Nodes[0].Addr == addr {
+			return s.Nodes
+		}
+	}
+	return []redis.ClusterNode{{ID: nodeID(addr), Addr: addr}}
+}
+
+// migrate assigns slot to the shard of the master addr, the slot ranges are split and
+// merged so that CLUSTER SLOTS reports them like redis-server does.
+func (t *clusterTopology) migrate(slot int, addr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.migrating, slot)
+	nodes := t.shardNodes(addr)
+
+	var slots []redis.ClusterSlot
+	for _, s := range t.slots {
+		if slot < s.Start || slot > s.End {
+			slots = append(slots, s)
+			continue
+		}
+		if s.Start < slot {
+			slots = append(slots, redis.ClusterSlot{Start: s.Start, End: slot - 1, Nodes: s.Nodes})
+		}
+		if slot < s.End {
+			slots = append(slots, redis.ClusterSlot{Start: slot + 1, End: s.End, Nodes: s.Nodes})
+		}
+	}
+	slots = append(slots, redis.ClusterSlot{Start: slot, End: slot, Nodes: nodes})
+	sort.Slice(slots, func(i, j int) bool {
+		return slots[i].Start < slots[j].Start
+	})
+
+	merged := slots[:1]
+	for _, s := range slots[1:] {
+		last := &merged[len(merged)-1]
+		if last.End+1 == s.Start && sameNodes(last.Nodes, s.Nodes) {
+			last.End = s.End
+			continue
+		}
+		merged = append(merged, s)
+	}
+	t.slots = merged
+}
+
+func sameNodes(a, b []redis.ClusterNode) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Addr != b[i].Addr {
+			return false
+		}
+	}
+	return true
+}
+
+// failover promotes the replica addr to master of the slots it replicates, their master becomes a replica.
+func (t *clusterTopology) failover(addr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, s := range t.slots {
+		for j, node := range s.Nodes {
+			if j > 0 && node.Addr == addr {
+				nodes := append([]redis.ClusterNode(nil), s.Nodes...)
+				nodes[0], nodes[j] = nodes[j], nodes[0]
+				t.slots[i].Nodes = nodes
+			}
+		}
+	}
+}
+
+func (t *clusterTopology) setDown(addr string, failure NodeFailure) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if failure == 0 {
+		delete(t.down, addr)
+		return
+	}
+	t.down[addr] = failure
+}
+
+// failure returns the error of the node addr if it is down.
+func (t *clusterTopology) failure(addr string) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if f, ok := t.down[addr]; ok {
+		return f.error()
+	}
+	return nil
+}
+
+func (t *clusterTopology) serve(addr string, n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.served[addr] += n
+}
+
+func (t *clusterTopology) servedCount(addr string) int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.served[addr]
+}
+
+// startMigration marks slot as being migrated to addr, its master answers ASK.
+func (t *clusterTopology) startMigration(slot int, addr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.migrating[slot] = addr
+}
+
+// redirect returns the error redis-server replies when cmd is sent to addr while addr does
+// not serve its slot: MOVED to the master of the slot, or ASK to the target of a migration.
+func (t *clusterTopology) redirect(ctx context.Context, addr string, cmd redis.Cmder) error {
+	slot, ok := cmdSlot(cmd)
+	if !ok {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	nodes := t.slotNodesLocked(slot)
+	if len(nodes) == 0 {
+		return newRedisError("CLUSTERDOWN Hash slot not served")
+	}
+	master := nodes[0].Addr
+
+	if target, ok := t.migrating[slot]; ok {
+		if addr == master {
+			t.ask++
+			return newRedisError(fmt.Sprintf("ASK %d %s", slot, target))
+		}
+		if addr == target && ctx.Value(askingKey{}) != nil {
+			return nil
+		}
+	}
+
+	for _, node := range nodes {
+		if node.Addr == addr {
+			return nil
+		}
+	}
+	t.moved++
+	return newRedisError(fmt.Sprintf("MOVED %d %s", slot, master))
+}
+
+// redirectTarget returns the address of a MOVED or ASK error returned by redirect.
+func redirectTarget(err error) (addr string, ask, ok bool) {
+	if err == nil {
+		return "", false, false
+	}
+	fields := strings.Fields(err.Error())
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return "", false, false
+	}
+	return fields[2], fields[0] == "ASK", true
+}
+
+var errReadOnlyReplica = newRedisError("READONLY You can't write against a read only replica.")
+
+// rejectReplicaWrite returns the READONLY error of a replica if cmd is a write command sent to addr.
+// A command sent after ASKING is served like on a master.
+func (t *clusterTopology) rejectReplicaWrite(ctx context.Context, addr string, cmd redis.Cmder) error {
+	if !isWriteCmd(cmd) || ctx.Value(askingKey{}) != nil || t.role(addr) != roleReplica {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.replicaWrites++
+	return errReadOnlyReplica
+}
+
+func (t *clusterTopology) replicaWriteCount() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.replicaWrites
+}
+
+func (t *clusterTopology) redirects() (moved, ask int) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.moved, t.ask
+}
+
+// clusterSlots returns the reply of CLUSTER SLOTS.
+func (t *clusterTopology) clusterSlots() []redis.ClusterSlot {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	slots := make([]redis.ClusterSlot, len(t.slots))
+	for i, slot := range t.slots {
+		slots[i] = redis.ClusterSlot{
+			Start: slot.Start,
+			End:   slot.End,
+			Nodes: append([]redis.ClusterNode(nil), slot.Nodes...),
+		}
+	}
+	return slots
+}
+
+// clusterShards returns the reply of CLUSTER SHARDS, a shard is a master with its replicas.
+func (t *clusterTopology) clusterShards() []redis.ClusterShard {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var shards []redis.ClusterShard
+	index := make(map[string]int)
+	for _, slot := range t.slots {
+		if len(slot.Nodes) == 0 {
+			continue
+		}
+		master := slot.Nodes[0].Addr
+		i, ok := index[master]
+		if !ok {
+			i = len(shards)
+			index[master] = i
+
+			nodes := make([]redis.Node, len(slot.Nodes))
+			for j, node := range slot.Nodes {
+				role := "master"
+				if j > 0 {
+					role = "replica"
+				}
+				host, port, _ := net.SplitHostPort(node.Addr)
+				p, _ := strconv.ParseInt(port, 10, 64)
+				nodes[j] = redis.Node{
+					ID:       node.ID,
+					Endpoint: host,
+					IP:       host,
+					Port:     p,
+					Role:     role,
+					Health:   "online",
+				}
+			}
+			shards = append(shards, redis.ClusterShard{Nodes: nodes})
+		}
+		shards[i].Slots = append(shards[i].Slots, redis.SlotRange{Start: int64(slot.Start), End: int64(slot.End)})
+	}
+	return shards
+}
+
+// answer replies to the commands every node of the topology answers by itself.
+func (t *clusterTopology) answer(cmd redis.Cmder) bool {
+	switch cmd.Name() {
+	case "cluster":
+		switch subCommand(cmd) {
+		case "slots":
+			if c, ok := cmd.(*redis.ClusterSlotsCmd); ok {
+				c.SetVal(t.clusterSlots())
+				return true
+			}
+		case "shards":
+			if c, ok := cmd.(*redis.ClusterShardsCmd); ok {
+				c.SetVal(t.clusterShards())
+				return true
+			}
+		}
+	case "command":
+		if c, ok := cmd.(*redis.CommandsInfoCmd); ok && len(cmd.Args()) == 1 {
+			c.SetVal(commandsInfo())
+			return true
+		}
+	}
+	return false
+}
+
+// nodeKey carries the address of the node serving a command in its context.
+type nodeKey struct{}
+
+func withNode(ctx context.Context, addr string) context.Context {
+	return context.WithValue(ctx, nodeKey{}, addr)
+}
+
+// servingNode returns the address of the node serving the command issued with ctx,
+// an empty string if the command was not routed by a simulated cluster.
+func servingNode(ctx context.Context) string {
+	addr, _ := ctx.Value(nodeKey{}).(string)
+	return addr
+}
+
+// newNodeClient is the ClusterOptions.NewClient of a simulated cluster, the commands
+// of a node client are processed by the mock with the address of the node.
+func (m *mock) newNodeClient(opt *redis.Options) *redis.Client {
+	addr := opt.Addr
+	client := redis.NewClient(opt)
+	client.AddHook(redisClientHook{
+		fn: func(ctx context.Context, cmd redis.Cmder) error {
+			return m.processNode(withNode(ctx, addr), cmd)
+		},
+		pipeline: func(ctx context.Context, cmds []redis.Cmder) error {
+			return m.processNodePipeline(withNode(ctx, addr), cmds)
+		},
+	})
+	return client
+}
+
+// askingKey marks the context of a command sent after ASKING.
+type askingKey struct{}
+
+func (m *mock) processNode(ctx context.Context, cmd redis.Cmder) error {
+	if err := m.topology.failure(servingNode(ctx)); err != nil {
+		cmd.SetErr(err)
+		return err
+	}
+	if m.topology.answer(cmd) {
+		return nil
+	}
+	if err := m.topology.redirect(ctx, servingNode(ctx), cmd); err != nil {
+		cmd.SetErr(err)
+		return err
+	}
+	if err := m.topology.rejectReplicaWrite(ctx, servingNode(ctx), cmd); err != nil {
+		cmd.SetErr(err)
+		return err
+	}
+	m.topology.serve(servingNode(ctx), 1)
+	return m.process(ctx, cmd)
+}
+
+// processNodePipeline handles the ASKING pipeline the ClusterClient sends after an ASK
+// reply, other pipelines are matched like the pipelines of a client.
+func (m *mock) processNodePipeline(ctx context.Context, cmds []redis.Cmder) error {
+	if len(cmds) == 2 && cmds[0].Name() == "asking" {
+		if c, ok := cmds[0].(*redis.Cmd); ok {
+			c.SetVal("OK")
+		}
+		return m.processNode(context.WithValue(ctx, askingKey{}, true), cmds[1])
+	}
+	return m.processNodeCmds(ctx, cmds, 0)
+}
+
+// processNodeCmds serves the pipelined cmds of a node. The ClusterClient retries the MOVED
+// and ASK replies of a pipeline while it reads them from the connection, which the hook
+// of the mock replaces, so the redirected commands are sent to their node here.
+func (m *mock) processNodeCmds(ctx context.Context, cmds []redis.Cmder, redirects int) error {
+	addr := servingNode(ctx)
+	if err := m.topology.failure(addr); err != nil {
+		setCmdsErr(cmds, err)
+		return err
+	}
+
+	var served, redirected []redis.Cmder
+	var err error
+	for _, cmd := range cmds {
+		if redirectErr := m.topology.redirect(ctx, addr, cmd); redirectErr != nil {
+			cmd.SetErr(redirectErr)
+			redirected = append(redirected, cmd)
+			continue
+		}
+		if readOnlyErr := m.topology.rejectReplicaWrite(ctx, addr, cmd); readOnlyErr != nil {
+			cmd.SetErr(readOnlyErr)
+			if err == nil {
+				err = readOnlyErr
+			}
+			continue
+		}
+		served = append(served, cmd)
+	}
+
+	if len(served) > 0 {
+		m.topology.serve(addr, len(served))
+		if servedErr := m.processPipeline(ctx, served); err == nil {
+			err = servedErr
+		}
+	}
+	if redirects >= m.topology.maxRedirects {
+		if err == nil && len(redirected) > 0 {
+			err = redirected[0].Err()
+		}
+		return err
+	}
+
+	moved := make(map[string][]redis.Cmder)
+	var targets []string
+	for _, cmd := range redirected {
+		target, ask, _ := redirectTarget(cmd.Err())
+		cmd.SetErr(nil)
+		if ask {
+			if askErr := m.processNode(context.WithValue(withNode(ctx, target), askingKey{}, true), cmd); err == nil {
+				err = askErr
+			}
+			continue
+		}
+		if _, ok := moved[target]; !ok {
+			targets = append(targets, target)
+		}
+		moved[target] = append(moved[target], cmd)
+	}
+	for _, target := range targets {
+		if movedErr := m.processNodeCmds(withNode(ctx, target), moved[target], redirects+1); err == nil {
+			err = movedErr
+		}
+	}
+	return err
+}
+
+// placement restricts an expectation to the commands served by some nodes of a simulated
+// cluster, see Node, Master and Replica. err is set if no node can serve the expectation.
+type placement struct {
+	addr string
+	role string
+	err  error
+
+	// bySlot restricts the keys of the command to slot
+	bySlot bool
+	slot   int
+}
+
+const (
+	roleMaster  = "master"
+	roleReplica = "replica"
+)
+
+func (p placement) String() string {
+	switch {
+	case p.addr != "":
+		return p.addr
+	case p.role != "":
+		return p.role
+	}
+	return fmt.Sprintf("slot %d", p.slot)
+}
+
+// matchPlacement checks that cmd was served by a node of the placement of expect.
+func (m *mock) matchPlacement(ctx context.Context, expect expectation, cmd redis.Cmder) error {
+	p := expect.placement()
+	if p.bySlot {
+		if err := matchSlot(p.slot, cmd); err != nil {
+			return err
+		}
+	}
+	if p.addr == "" && p.role == "" {
+		return nil
+	}
+	if p.err != nil {
+		return p.err
+	}
+
+	served := servingNode(ctx)
+	if p.addr != "" && served != p.addr {
+		return fmt.Errorf("node not match, expectation '%s', but call to cmd '%+v' served by '%s'", p.addr, cmd.Args(), served)
+	}
+	if p.role != "" && m.topology.role(served) != p.role {
+		return fmt.Errorf("node not match, expectation %s, but call to cmd '%+v' served by %s '%s'",
+			p.role, cmd.Args(), m.topology.role(served), served)
+	}
+	return nil
+}
+
+// place checks that a node of p can serve the keys of expect.
+func (m *mock) place(p placement, expect expectation) error {
+	if m.topology == nil {
+		return fmt.Errorf("%s is not part of a simulated cluster, see NewClusterMockWithTopology", p)
+	}
+	if p.addr != "" && !m.topology.has(p.addr) {
+		return fmt.Errorf("node '%s' is not part of the cluster topology", p.addr)
+	}
+
+	cmd := expect.command()
+	if cmd == nil {
+		return nil
+	}
+	slot, ok := cmdSlot(cmd)
+	if !ok {
+		return nil
+	}
+
+	nodes := m.topology.slotNodes(slot)
+	switch {
+	case p.addr != "" && !m.topology.serves(p.addr, slot):
+		var owners []string
+		for _, node := range nodes {
+			owners = append(owners, node.Addr)
+		}
+		return fmt.Errorf("cmd '%+v' hashes to slot %d served by [%s], not by node '%s'",
+			cmd.Args(), slot, strings.Join(owners, " "), p.addr)
+	case p.role == roleReplica && len(nodes) < 2:
+		return fmt.Errorf("cmd '%+v' hashes to slot %d, it has no replica", cmd.Args(), slot)
+	}
+	return nil
+}
+
+// matchSlot checks that every key of cmd hashes to slot.
+func matchSlot(slot int, cmd redis.Cmder) error {
+	keys := cmdKeys(cmd)
+	if len(keys) == 0 {
+		if n, ok := cmdSlot(cmd); ok && n == slot {
+			return nil
+		}
+		return fmt.Errorf("slot not match, expectation slot %d, but call to cmd '%+v' has no key", slot, cmd.Args())
+	}
+	for _, key := range keys {
+		if n := Slot(key); n != slot {
+			return fmt.Errorf("slot not match, expectation slot %d, but call to cmd '%+v' has key '%s' in slot %d",
+				slot, cmd.Args(), key, n)
+		}
+	}
+	return nil
+}
+
+// answerSlot answers CLUSTER KEYSLOT with the slot of its key, and CLUSTER GETKEYSINSLOT
+// with the keys of the expectations hashing to its slot.
+func (m *mock) answerSlot(cmd redis.Cmder) bool {
+	args := cmd.Args()
+	if cmd.Name() != "cluster" || len(args) < 3 {
+		return false
+	}
+
+	switch c := cmd.(type) {
+	case *redis.IntCmd:
+		if subCommand(cmd) == "keyslot" {
+			c.SetVal(int64(Slot(argString(cmd, 2))))
+			return true
+		}
+	case *redis.StringSliceCmd:
+		slot, ok := cmdSlot(cmd)
+		if subCommand(cmd) != "getkeysinslot" || !ok {
+			return false
+		}
+		count := -1
+		if len(args) > 3 {
+			if n, err := strconv.Atoi(argString(cmd, 3)); err == nil {
+				count = n
+			}
+		}
+		c.SetVal(m.keysInSlot(slot, count))
+		return true
+	}
+	return false
+}
+
+// keysInSlot returns up to count keys of the registered expectations hashing to slot.
+func (m *mock) keysInSlot(slot, count int) []string {
+	keys := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, e := range m.expected {
+		cmd := e.command()
+		if cmd == nil {
+			continue
+		}
+		for _, key := range cmdKeys(cmd) {
+			if count >= 0 && len(keys) == count {
+				return keys
+			}
+			if !seen[key] && Slot(key) == slot {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// cmdSlot returns the slot a ClusterClient routes cmd to, false if it is sent to a random slot.
+func cmdSlot(cmd redis.Cmder) (int, bool) {
+	args := cmd.Args()
+	if len(args) > 2 && cmd.Name() == "cluster" && subCommand(cmd) == "getkeysinslot" {
+		if slot, ok := args[2].(int); ok {
+			return slot, true
+		}
+	}
+
+	pos := firstKeyPos(cmd)
+	if pos == 0 || pos >= len(args) {
+		return 0, false
+	}
+	return Slot(argString(cmd, pos)), true
+}
+
+var errCrossSlot = newRedisError("CROSSSLOT Keys in request don't hash to the same slot")
+
+// checkCrossSlot returns the CROSSSLOT error of redis-server if the keys of cmd hash to different slots.
+func checkCrossSlot(cmd redis.Cmder) error {
+	keys := cmdKeys(cmd)
+	if len(keys) < 2 {
+		return nil
+	}
+	for _, key := range keys[1:] {
+		if Slot(key) != Slot(keys[0]) {
+			return errCrossSlot
+		}
+	}
+	return nil
+}
+
+// Slot returns the cluster hash slot of key: the CRC16 of its hash tag, or of the whole key, modulo 16384.
+func Slot(key string) int {
+	return int(crc16(HashTag(key))) % slotNumber
+}
+
+// HashTag returns the part of key between the first '{' and the next '}' if it is not empty,
+// otherwise the whole key. Keys with the same hash tag hash to the same slot.
+func HashTag(key string) string {
+	if s := strings.IndexByte(key, '{'); s > -1 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			return key[s+1 : s+e+1]
+		}
+	}
+	return key
+}
+
+// crc16 is the CRC16-CCITT (XMODEM) checksum used by redis cluster.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^s[i]]
+	}
+	return crc
+}
+
+var crc16Table = func() (table [256]uint16) {
+	for i := range table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
@@ -1,8 +1,11 @@
 package redismock
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net"
+	"syscall"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -130,7 +133,7 @@ var _ = Describe("Cluster", func() {
 			}).ExpectGet("key").SetVal("OK")
 
 			get := client.Get(ctx, "key")
-			Expect(get.Err()).To(Equal(errors.New("mismatch")))
+			Expect(get.Err()).To(MatchError("mismatch"))
 			Expect(get.Val()).To(Equal(""))
 
 			set := client.Incr(ctx, "key")
@@ -173,4 +176,459 @@ var _ = Describe("Cluster", func() {
 			Expect(get.Val()).To(Equal(""))
 		})
 	})
+
+	Describe("slots", func() {
+		It("hash slot", func() {
+			Expect(Slot("foo")).To(Equal(12182))
+			Expect(Slot("bar")).To(Equal(5061))
+			Expect(Slot("{bar}.foo")).To(Equal(5061))
+			Expect(HashTag("{user:1}.name")).To(Equal("user:1"))
+			Expect(HashTag("{}.name")).To(Equal("{}.name"))
+			Expect(HashTag("user:1")).To(Equal("user:1"))
+		})
+
+		It("first key position", func() {
+			objectCmd := redis.NewStringCmd(ctx, "object", "encoding", "key")
+			objectCmd.SetFirstKeyPos(2)
+			pos, ok := cmdKeyPos(objectCmd)
+			Expect(ok).To(BeTrue())
+			Expect(pos).To(Equal(2))
+			Expect(firstKeyPos(objectCmd)).To(Equal(2))
+
+			// a Cmder without the keyPos of go-redis uses the key positions of the command table
+			getCmd := struct{ redis.Cmder }{redis.NewStringCmd(ctx, "get", "key")}
+			_, ok = cmdKeyPos(getCmd)
+			Expect(ok).To(BeFalse())
+			Expect(firstKeyPos(getCmd)).To(Equal(1))
+		})
+
+		It("for slot", func() {
+			clusterMock.ForSlot(5061).Regexp().ExpectGet("bar").SetVal("1")
+			clusterMock.ForSlot(5061).ExpectMGet("bar", "{bar}.1").SetVal([]interface{}{"1", "2"})
+
+			Expect(client.Get(ctx, "bar").Val()).To(Equal("1"))
+			Expect(client.MGet(ctx, "bar", "{bar}.1").Val()).To(Equal([]interface{}{"1", "2"}))
+
+			clusterMock.ForSlot(5061).ExpectGet("foo").SetVal("1")
+			Expect(client.Get(ctx, "foo").Err()).To(MatchError(
+				"slot not match, expectation slot 5061, but call to cmd '[get foo]' has key 'foo' in slot 12182"))
+
+			// let AfterEach pass
+			clusterMock.ClearExpect()
+		})
+
+		It("for hash tag", func() {
+			clusterMock.ForHashTag("{user:1}").ExpectHGet("{user:1}.profile", "name").SetVal("alice")
+			Expect(client.HGet(ctx, "{user:1}.profile", "name").Val()).To(Equal("alice"))
+
+			clusterMock.ForHashTag("user:1").ExpectHGet("{user:1}.profile", "name").SetVal("bob")
+			Expect(client.HGet(ctx, "{user:1}.profile", "name").Val()).To(Equal("bob"))
+
+			clusterMock.ForHashTag("user:1").ExpectHGet("{user:2}.profile", "name").SetVal("carol")
+			Expect(client.HGet(ctx, "{user:2}.profile", "name").Err()).To(MatchError(HavePrefix(
+				"slot not match, expectation slot %d, but call to cmd '[hget {user:2}.profile name]'", Slot("user:1"))))
+
+			// let AfterEach pass
+			clusterMock.ClearExpect()
+		})
+
+		It("answers keyslot and getkeysinslot", func() {
+			clusterMock.ExpectSet("{bar}.1", "1", 0).SetVal("OK")
+			clusterMock.ExpectSet("foo", "1", 0).SetVal("OK")
+			clusterMock.ExpectClusterKeySlot("bar")
+			clusterMock.ExpectClusterGetKeysInSlot(5061, 10)
+			clusterMock.ExpectClusterKeySlot("bar").SetVal(1)
+
+			Expect(client.Set(ctx, "{bar}.1", "1", 0).Err()).NotTo(HaveOccurred())
+			Expect(client.Set(ctx, "foo", "1", 0).Err()).NotTo(HaveOccurred())
+			Expect(client.ClusterKeySlot(ctx, "bar").Val()).To(Equal(int64(5061)))
+			Expect(client.ClusterGetKeysInSlot(ctx, 5061, 10).Val()).To(Equal([]string{"{bar}.1"}))
+			Expect(client.ClusterKeySlot(ctx, "bar").Val()).To(Equal(int64(1)))
+		})
+	})
+
+	Describe("cross slot", func() {
+		BeforeEach(func() {
+			clusterMock.CheckCrossSlot(true)
+		})
+
+		It("rejects keys of different slots", func() {
+			crossSlot := "CROSSSLOT Keys in request don't hash to the same slot"
+
+			Expect(client.MGet(ctx, "a", "b").Err()).To(MatchError(crossSlot))
+			Expect(client.Del(ctx, "a", "b").Err()).To(MatchError(crossSlot))
+			Expect(client.SUnionStore(ctx, "dst", "a", "b").Err()).To(MatchError(crossSlot))
+			Expect(client.ZUnionStore(ctx, "dst", &redis.ZStore{Keys: []string{"dst", "a"}}).Err()).To(MatchError(crossSlot))
+			Expect(client.Eval(ctx, "return 1", []string{"a", "b"}).Err()).To(MatchError(crossSlot))
+			Expect(client.XRead(ctx, &redis.XReadArgs{Streams: []string{"a", "b", "0", "0"}, Block: -1}).Err()).To(MatchError(crossSlot))
+			Expect(client.MSet(ctx, "a", "1", "b", "2").Err()).To(MatchError(crossSlot))
+		})
+
+		It("accepts keys of the same slot", func() {
+			clusterMock.ExpectMGet("{user1}:a", "{user1}:b").SetVal([]interface{}{"1", "2"})
+			clusterMock.ExpectMSet("{user1}:a", "a", "{user1}:b", "b").SetVal("OK")
+			clusterMock.ExpectEval("return 1", []string{"{user1}:a", "{user1}:b"}, "a", "b").SetVal(int64(1))
+			clusterMock.ExpectGet("a").SetVal("1")
+
+			Expect(client.MGet(ctx, "{user1}:a", "{user1}:b").Val()).To(Equal([]interface{}{"1", "2"}))
+			// values are not keys
+			Expect(client.MSet(ctx, "{user1}:a", "a", "{user1}:b", "b").Val()).To(Equal("OK"))
+			Expect(client.Eval(ctx, "return 1", []string{"{user1}:a", "{user1}:b"}, "a", "b").Val()).To(Equal(int64(1)))
+			Expect(client.Get(ctx, "a").Val()).To(Equal("1"))
+		})
+
+		It("keyless commands", func() {
+			clusterMock.ExpectPing().SetVal("PONG")
+			clusterMock.ExpectInfo().SetVal("# Server")
+
+			Expect(client.Ping(ctx).Val()).To(Equal("PONG"))
+			Expect(client.Info(ctx).Val()).To(Equal("# Server"))
+		})
+
+		It("disabled", func() {
+			clusterMock.CheckCrossSlot(false)
+			clusterMock.ExpectDel("a", "b").SetVal(2)
+
+			Expect(client.Del(ctx, "a", "b").Val()).To(Equal(int64(2)))
+		})
+	})
+
+	Describe("replicas", func() {
+		BeforeEach(func() {
+			client, clusterMock = NewClusterMockWithTopology(ClusterTopology{
+				Slots: []redis.ClusterSlot{
+					{Start: 0, End: 8191, Nodes: []redis.ClusterNode{{Addr: "10.0.0.1:7000"}, {Addr: "10.0.0.3:7000"}}},
+					{Start: 8192, End: 16383, Nodes: []redis.ClusterNode{{Addr: "10.0.0.2:7000"}}},
+				},
+				Options: &redis.ClusterOptions{ReadOnly: true},
+			})
+		})
+
+		It("reads from the replica", func() {
+			// slot("bar") = 5061
+			clusterMock.Master().ExpectSet("bar", "new", 0).SetVal("OK")
+			clusterMock.Replica().ExpectGet("bar").SetVal("old")
+			clusterMock.Node("10.0.0.3:7000").ExpectGet("bar").SetVal("new")
+
+			Expect(client.Set(ctx, "bar", "new", 0).Val()).To(Equal("OK"))
+			Expect(client.Get(ctx, "bar").Val()).To(Equal("old"))
+			Expect(client.Get(ctx, "bar").Val()).To(Equal("new"))
+		})
+
+		It("served by the master", func() {
+			clusterMock.Master().ExpectGet("bar").SetVal("1")
+
+			Expect(client.Get(ctx, "bar").Err()).To(MatchError(
+				"node not match, expectation master, but call to cmd '[get bar]' served by replica '10.0.0.3:7000'"))
+
+			clusterMock.ClearExpect()
+			clusterMock.Replica().ExpectGet("foo").SetVal("1")
+			Expect(client.Get(ctx, "foo").Err()).To(MatchError("cmd '[get foo]' hashes to slot 12182, it has no replica"))
+
+			// let AfterEach pass
+			clusterMock.ClearExpect()
+		})
+
+		It("failing replica", func() {
+			clusterMock.Replica().ExpectGet("bar").SetErr(errors.New("LOADING Redis is loading the dataset in memory"))
+			clusterMock.Master().ExpectGet("bar").SetVal("1")
+
+			Expect(client.Get(ctx, "bar").Val()).To(Equal("1"))
+		})
+
+		It("combined placement", func() {
+			clusterMock.With(OnReplica, InSlot(5061)).ExpectGet("bar").SetVal("old")
+			clusterMock.Master().ForSlot(5061).ExpectSet("bar", "new", 0).SetVal("OK")
+
+			Expect(client.Get(ctx, "bar").Val()).To(Equal("old"))
+			Expect(client.Set(ctx, "bar", "new", 0).Val()).To(Equal("OK"))
+		})
+
+		It("writes to a replica", func() {
+			clusterMock.MatchExpectationsInOrder(false)
+			clusterMock.Node("10.0.0.1:7000").ExpectFlushDB().SetVal("OK")
+			clusterMock.Node("10.0.0.2:7000").ExpectFlushDB().SetVal("OK")
+
+			err := client.ForEachShard(ctx, func(ctx context.Context, node *redis.Client) error {
+				return node.FlushDB(ctx).Err()
+			})
+			Expect(err).To(MatchError("READONLY You can't write against a read only replica."))
+			Expect(clusterMock.ReplicaWrites()).To(Equal(1))
+		})
+
+		It("pipelined writes to a replica", func() {
+			clusterMock.Replica().ExpectGet("bar").SetVal("old")
+
+			var get *redis.StringCmd
+			err := client.ForEachShard(ctx, func(ctx context.Context, node *redis.Client) error {
+				if node.Options().Addr != "10.0.0.3:7000" {
+					return nil
+				}
+				_, err := node.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+					get = pipe.Get(ctx, "bar")
+					pipe.Set(ctx, "bar", "new", 0)
+					return nil
+				})
+				return err
+			})
+			Expect(err).To(MatchError("READONLY You can't write against a read only replica."))
+			Expect(get.Val()).To(Equal("old"))
+			Expect(clusterMock.ReplicaWrites()).To(Equal(1))
+			Expect(clusterMock.Served("10.0.0.3:7000")).To(Equal(1))
+		})
+	})
+
+	Describe("node failures", func() {
+		BeforeEach(func() {
+			client, clusterMock = NewClusterMockWithTopology(ClusterTopology{
+				Slots: []redis.ClusterSlot{
+					{Start: 0, End: 8191, Nodes: []redis.ClusterNode{{Addr: "10.0.0.1:7000"}, {Addr: "10.0.0.3:7000"}}},
+					{Start: 8192, End: 16383, Nodes: []redis.ClusterNode{{Addr: "10.0.0.2:7000"}}},
+				},
+				Options: &redis.ClusterOptions{MinRetryBackoff: time.Millisecond, MaxRetryBackoff: time.Millisecond},
+			})
+		})
+
+		It("connection refused", func() {
+			clusterMock.NodeDown("10.0.0.2:7000", NodeRefused)
+			err := client.Get(ctx, "foo").Err()
+			Expect(errors.Is(err, syscall.ECONNREFUSED)).To(BeTrue())
+
+			clusterMock.NodeUp("10.0.0.2:7000")
+			clusterMock.ExpectGet("foo").SetVal("1")
+			Expect(client.Get(ctx, "foo").Val()).To(Equal("1"))
+			Expect(clusterMock.Served("10.0.0.2:7000")).To(Equal(1))
+		})
+
+		It("timeout", func() {
+			clusterMock.NodeDown("10.0.0.2:7000", NodeTimeout)
+			err := client.Get(ctx, "foo").Err()
+
+			var netErr net.Error
+			Expect(errors.As(err, &netErr)).To(BeTrue())
+			Expect(netErr.Timeout()).To(BeTrue())
+		})
+
+		It("cluster down", func() {
+			clusterMock.NodeDown("10.0.0.1:7000", NodeClusterDown)
+			Expect(client.Get(ctx, "bar").Err()).To(MatchError("CLUSTERDOWN The cluster is down"))
+
+			clusterMock.ExpectGet("foo").SetVal("1")
+			Expect(client.Get(ctx, "foo").Val()).To(Equal("1"))
+		})
+
+		It("failover", func() {
+			clusterMock.ExpectGet("bar").SetVal("1")
+			Expect(client.Get(ctx, "bar").Val()).To(Equal("1"))
+			Expect(clusterMock.Served("10.0.0.1:7000")).To(Equal(1))
+
+			clusterMock.NodeDown("10.0.0.1:7000", NodeRefused)
+			clusterMock.Failover("10.0.0.3:7000")
+			client.ReloadState(ctx)
+
+			clusterMock.Node("10.0.0.3:7000").ExpectGet("bar").SetVal("2")
+			Eventually(func() string {
+				return client.Get(ctx, "bar").Val()
+			}).Should(Equal("2"))
+			Expect(clusterMock.Served("10.0.0.1:7000")).To(Equal(1))
+			Expect(clusterMock.Served("10.0.0.3:7000")).To(Equal(1))
+
+			slots, err := client.ClusterSlots(ctx).Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(slots[0].Nodes[0].Addr).To(Equal("10.0.0.3:7000"))
+			Expect(slots[0].Nodes[1].Addr).To(Equal("10.0.0.1:7000"))
+		})
+	})
+
+	Describe("topology", func() {
+		BeforeEach(func() {
+			client, clusterMock = NewClusterMockWithTopology(ClusterTopology{
+				Slots: []redis.ClusterSlot{
+					{Start: 0, End: 5460, Nodes: []redis.ClusterNode{{Addr: "10.0.0.1:7000"}, {Addr: "10.0.0.4:7000"}}},
+					{Start: 5461, End: 10922, Nodes: []redis.ClusterNode{{Addr: "10.0.0.2:7000"}}},
+					{Start: 10923, End: 16383, Nodes: []redis.ClusterNode{{Addr: "10.0.0.3:7000"}}},
+				},
+			})
+		})
+
+		It("routes keys to their node", func() {
+			// slot("bar") = 5061, slot("c") = 7365, slot("foo") = 12182
+			clusterMock.Node("10.0.0.1:7000").ExpectSet("bar", "1", 0).SetVal("OK")
+			clusterMock.Node("10.0.0.2:7000").ExpectGet("c").SetVal("2")
+			clusterMock.Node("10.0.0.3:7000").ExpectGet("foo").SetVal("3")
+			clusterMock.ExpectGet("bar").SetVal("1")
+
+			Expect(client.Set(ctx, "bar", "1", 0).Val()).To(Equal("OK"))
+			Expect(client.Get(ctx, "c").Val()).To(Equal("2"))
+			Expect(client.Get(ctx, "foo").Val()).To(Equal("3"))
+			Expect(client.Get(ctx, "bar").Val()).To(Equal("1"))
+		})
+
+		It("served by another node", func() {
+			clusterMock.Node("10.0.0.1:7000").ExpectGet("foo").SetVal("1")
+
+			err := client.Get(ctx, "foo").Err()
+			Expect(err).To(MatchError("cmd '[get foo]' hashes to slot 12182 served by [10.0.0.3:7000], not by node '10.0.0.1:7000'"))
+
+			clusterMock.ClearExpect()
+			clusterMock.Node("10.0.0.9:7000").ExpectGet("foo").SetVal("1")
+			Expect(client.Get(ctx, "foo").Err()).To(MatchError("node '10.0.0.9:7000' is not part of the cluster topology"))
+
+			// let AfterEach pass
+			clusterMock.ClearExpect()
+		})
+
+		It("cluster slots and shards", func() {
+			slots, err := client.ClusterSlots(ctx).Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(slots).To(HaveLen(3))
+			Expect(slots[0].Start).To(Equal(0))
+			Expect(slots[0].End).To(Equal(5460))
+			Expect(slots[0].Nodes).To(HaveLen(2))
+			Expect(slots[0].Nodes[1].Addr).To(Equal("10.0.0.4:7000"))
+			Expect(slots[0].Nodes[0].ID).To(HaveLen(40))
+
+			shards, err := client.ClusterShards(ctx).Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(shards).To(HaveLen(3))
+			Expect(shards[0].Slots).To(Equal([]redis.SlotRange{{Start: 0, End: 5460}}))
+			Expect(shards[0].Nodes[0].Role).To(Equal("master"))
+			Expect(shards[0].Nodes[1].Role).To(Equal("replica"))
+			Expect(shards[0].Nodes[1].Port).To(Equal(int64(7000)))
+		})
+
+		It("for each master", func() {
+			clusterMock.MatchExpectationsInOrder(false)
+			clusterMock.Node("10.0.0.1:7000").ExpectFlushDB().SetVal("OK")
+			clusterMock.Node("10.0.0.2:7000").ExpectFlushDB().SetVal("OK")
+			clusterMock.Node("10.0.0.3:7000").ExpectFlushDB().SetVal("OK")
+
+			err := client.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+				return node.FlushDB(ctx).Err()
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("for each shard", func() {
+			clusterMock.MatchExpectationsInOrder(false)
+			for _, addr := range []string{"10.0.0.1:7000", "10.0.0.2:7000", "10.0.0.3:7000", "10.0.0.4:7000"} {
+				clusterMock.Node(addr).ExpectPing().SetVal("PONG")
+			}
+
+			err := client.ForEachShard(ctx, func(ctx context.Context, node *redis.Client) error {
+				return node.Ping(ctx).Err()
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("moved", func() {
+			clusterMock.Node("10.0.0.1:7000").ExpectGet("bar").SetVal("0")
+			Expect(client.Get(ctx, "bar").Val()).To(Equal("0"))
+
+			clusterMock.MigrateSlot(5061, "10.0.0.2:7000")
+			clusterMock.Node("10.0.0.2:7000").ExpectGet("bar").SetVal("1")
+
+			Expect(client.Get(ctx, "bar").Val()).To(Equal("1"))
+			moved, ask := clusterMock.Redirects()
+			Expect(moved).To(Equal(1))
+			Expect(ask).To(Equal(0))
+
+			slots, err := client.ClusterSlots(ctx).Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(slots).To(HaveLen(5))
+			Expect(slots[1]).To(Equal(redis.ClusterSlot{
+				Start: 5061,
+				End:   5061,
+				Nodes: []redis.ClusterNode{{ID: slots[3].Nodes[0].ID, Addr: "10.0.0.2:7000"}},
+			}))
+			Expect(slots[2].Start).To(Equal(5062))
+			Expect(slots[2].Nodes[0].Addr).To(Equal("10.0.0.1:7000"))
+		})
+
+		It("ask", func() {
+			clusterMock.StartSlotMigration(5061, "10.0.0.2:7000")
+			clusterMock.Node("10.0.0.2:7000").ExpectGet("bar").SetVal("1")
+
+			Expect(client.Get(ctx, "bar").Err()).NotTo(HaveOccurred())
+			moved, ask := clusterMock.Redirects()
+			Expect(moved).To(Equal(0))
+			Expect(ask).To(Equal(1))
+
+			// without ASKING the target sends the client back to the master
+			err := client.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+				if node.Options().Addr != "10.0.0.2:7000" {
+					return nil
+				}
+				return node.Get(ctx, "bar").Err()
+			})
+			Expect(err).To(MatchError("MOVED 5061 10.0.0.1:7000"))
+
+			clusterMock.MigrateSlot(5061, "10.0.0.2:7000")
+			slots, err := client.ClusterSlots(ctx).Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(slots).To(HaveLen(5))
+		})
+
+		It("ask to a new node", func() {
+			clusterMock.StartSlotMigration(5061, "10.0.0.9:7000")
+			clusterMock.Master().ExpectSet("bar", "1", 0).SetVal("OK")
+
+			Expect(client.Set(ctx, "bar", "1", 0).Val()).To(Equal("OK"))
+			moved, ask := clusterMock.Redirects()
+			Expect(moved).To(Equal(0))
+			Expect(ask).To(Equal(1))
+			Expect(clusterMock.ReplicaWrites()).To(Equal(0))
+			Expect(clusterMock.Served("10.0.0.9:7000")).To(Equal(1))
+		})
+
+		It("pipeline moved", func() {
+			clusterMock.Node("10.0.0.1:7000").ExpectGet("bar").SetVal("0")
+			Expect(client.Get(ctx, "bar").Val()).To(Equal("0"))
+
+			clusterMock.MigrateSlot(5061, "10.0.0.2:7000")
+			clusterMock.Node("10.0.0.2:7000").ExpectGet("bar").SetVal("1")
+			clusterMock.Node("10.0.0.2:7000").ExpectSet("bar", "2", 0).SetVal("OK")
+
+			cmds, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Get(ctx, "bar")
+				pipe.Set(ctx, "bar", "2", 0)
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cmds[0].(*redis.StringCmd).Val()).To(Equal("1"))
+			Expect(cmds[1].(*redis.StatusCmd).Val()).To(Equal("OK"))
+
+			moved, ask := clusterMock.Redirects()
+			Expect(moved).To(Equal(2))
+			Expect(ask).To(Equal(0))
+			Expect(clusterMock.Served("10.0.0.1:7000")).To(Equal(1))
+			Expect(clusterMock.Served("10.0.0.2:7000")).To(Equal(2))
+		})
+
+		It("pipeline ask", func() {
+			clusterMock.StartSlotMigration(5061, "10.0.0.2:7000")
+			clusterMock.Node("10.0.0.2:7000").ExpectSet("bar", "1", 0).SetVal("OK")
+
+			cmds, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, "bar", "1", 0)
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cmds[0].(*redis.StatusCmd).Val()).To(Equal("OK"))
+
+			moved, ask := clusterMock.Redirects()
+			Expect(moved).To(Equal(0))
+			Expect(ask).To(Equal(1))
+			Expect(clusterMock.Served("10.0.0.2:7000")).To(Equal(1))
+		})
+
+		It("migrate back", func() {
+			clusterMock.MigrateSlot(5061, "10.0.0.2:7000")
+			clusterMock.MigrateSlot(5061, "10.0.0.1:7000")
+
+			slots, err := client.ClusterSlots(ctx).Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(slots).To(HaveLen(3))
+		})
+	})
 })
@@ -0,0 +1,565 @@
+package redismock
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// commandSpec is the COMMAND metadata of a command, as reported by redis-server 7.0.
+// Container commands (CLUSTER, OBJECT, XINFO...) report no keys, their subcommands have their own.
+type commandSpec struct {
+	arity int8
+	flags string
+	first int8
+	last  int8
+	step  int8
+}
+
+var commandSpecs = map[string]commandSpec{
+	// strings
+	"get":         {2, "readonly fast", 1, 1, 1},
+	"set":         {-3, "write denyoom", 1, 1, 1},
+	"setnx":       {3, "write denyoom fast", 1, 1, 1},
+	"setex":       {4, "write denyoom", 1, 1, 1},
+	"psetex":      {4, "write denyoom", 1, 1, 1},
+	"getset":      {3, "write denyoom fast", 1, 1, 1},
+	"getdel":      {2, "write fast", 1, 1, 1},
+	"getex":       {-2, "write fast", 1, 1, 1},
+	"getrange":    {4, "readonly", 1, 1, 1},
+	"setrange":    {4, "write denyoom", 1, 1, 1},
+	"substr":      {4, "readonly", 1, 1, 1},
+	"append":      {3, "write denyoom fast", 1, 1, 1},
+	"strlen":      {2, "readonly fast", 1, 1, 1},
+	"incr":        {2, "write denyoom fast", 1, 1, 1},
+	"decr":        {2, "write denyoom fast", 1, 1, 1},
+	"incrby":      {3, "write denyoom fast", 1, 1, 1},
+	"decrby":      {3, "write denyoom fast", 1, 1, 1},
+	"incrbyfloat": {3, "write denyoom fast", 1, 1, 1},
+	"mget":        {-2, "readonly fast", 1, -1, 1},
+	"mset":        {-3, "write denyoom", 1, -1, 2},
+	"msetnx":      {-3, "write denyoom", 1, -1, 2},
+	"lcs":         {-3, "readonly", 1, 2, 1},
+	"getbit":      {3, "readonly fast", 1, 1, 1},
+	"setbit":      {4, "write denyoom", 1, 1, 1},
+	"bitcount":    {-2, "readonly", 1, 1, 1},
+	"bitpos":      {-3, "readonly", 1, 1, 1},
+	"bitop":       {-4, "write denyoom", 2, -1, 1},
+	"bitfield":    {-2, "write denyoom", 1, 1, 1},
+	"bitfield_ro": {-2, "readonly fast", 1, 1, 1},
+
+	// keys
+	"del":         {-2, "write", 1, -1, 1},
+	"unlink":      {-2, "write fast", 1, -1, 1},
+	"exists":      {-2, "readonly fast", 1, -1, 1},
+	"touch":       {-2, "readonly fast", 1, -1, 1},
+	"type":        {2, "readonly fast", 1, 1, 1},
+	"ttl":         {2, "readonly fast", 1, 1, 1},
+	"pttl":        {2, "readonly fast", 1, 1, 1},
+	"expiretime":  {2, "readonly fast", 1, 1, 1},
+	"pexpiretime": {2, "readonly fast", 1, 1, 1},
+	"expire":      {-3, "write fast", 1, 1, 1},
+	"pexpire":     {-3, "write fast", 1, 1, 1},
+	"expireat":    {-3, "write fast", 1, 1, 1},
+	"pexpireat":   {-3, "write fast", 1, 1, 1},
+	"persist":     {2, "write fast", 1, 1, 1},
+	"dump":        {2, "readonly", 1, 1, 1},
+	"restore":     {-4, "write denyoom", 1, 1, 1},
+	"rename":      {3, "write", 1, 2, 1},
+	"renamenx":    {3, "write fast", 1, 2, 1},
+	"copy":        {-3, "write denyoom", 1, 2, 1},
+	"move":        {3, "write fast", 1, 1, 1},
+	"keys":        {2, "readonly", 0, 0, 0},
+	"scan":        {-2, "readonly", 0, 0, 0},
+	"randomkey":   {1, "readonly", 0, 0, 0},
+	"sort":        {-2, "write denyoom movablekeys", 1, 1, 1},
+	"sort_ro":     {-2, "readonly movablekeys", 1, 1, 1},
+	"migrate":     {-6, "write movablekeys", 3, 3, 1},
+	"object":      {-2, "", 0, 0, 0},
+	"wait":        {3, "noscript", 0, 0, 0},
+
+	// lists
+	"lpush":      {-3, "write denyoom fast", 1, 1, 1},
+	"rpush":      {-3, "write denyoom fast", 1, 1, 1},
+	"lpushx":     {-3, "write denyoom fast", 1, 1, 1},
+	"rpushx":     {-3, "write denyoom fast", 1, 1, 1},
+	"lpop":       {-2, "write fast", 1, 1, 1},
+	"rpop":       {-2, "write fast", 1, 1, 1},
+	"llen":       {2, "readonly fast", 1, 1, 1},
+	"lindex":     {3, "readonly", 1, 1, 1},
+	"lset":       {4, "write denyoom", 1, 1, 1},
+	"lrange":     {4, "readonly", 1, 1, 1},
+	"ltrim":      {4, "write", 1, 1, 1},
+	"lrem":       {4, "write", 1, 1, 1},
+	"linsert":    {5, "write denyoom", 1, 1, 1},
+	"lpos":       {-3, "readonly", 1, 1, 1},
+	"rpoplpush":  {3, "write denyoom", 1, 2, 1},
+	"lmove":      {5, "write denyoom", 1, 2, 1},
+	"lmpop":      {-4, "write movablekeys", 0, 0, 0},
+	"blpop":      {-3, "write noscript blocking", 1, -2, 1},
+	"brpop":      {-3, "write noscript blocking", 1, -2, 1},
+	"brpoplpush": {4, "write denyoom noscript blocking", 1, 2, 1},
+	"blmove":     {6, "write denyoom noscript blocking", 1, 2, 1},
+	"blmpop":     {-5, "write blocking movablekeys", 0, 0, 0},
+
+	// sets
+	"sadd":        {-3, "write denyoom fast", 1, 1, 1},
+	"srem":        {-3, "write fast", 1, 1, 1},
+	"scard":       {2, "readonly fast", 1, 1, 1},
+	"smembers":    {2, "readonly", 1, 1, 1},
+	"sismember":   {3, "readonly fast", 1, 1, 1},
+	"smismember":  {-3, "readonly fast", 1, 1, 1},
+	"spop":        {-2, "write fast", 1, 1, 1},
+	"srandmember": {-2, "readonly", 1, 1, 1},
+	"smove":       {4, "write fast", 1, 2, 1},
+	"sinter":      {-2, "readonly", 1, -1, 1},
+	"sintercard":  {-3, "readonly movablekeys", 0, 0, 0},
+	"sinterstore": {-3, "write denyoom", 1, -1, 1},
+	"sunion":      {-2, "readonly", 1, -1, 1},
+	"sunionstore": {-3, "write denyoom", 1, -1, 1},
+	"sdiff":       {-2, "readonly", 1, -1, 1},
+	"sdiffstore":  {-3, "write denyoom", 1, -1, 1},
+	"sscan":       {-3, "readonly", 1, 1, 1},
+
+	// hashes
+	"hset":         {-4, "write denyoom fast", 1, 1, 1},
+	"hsetnx":       {4, "write denyoom fast", 1, 1, 1},
+	"hmset":        {-4, "write denyoom fast", 1, 1, 1},
+	"hget":         {3, "readonly fast", 1, 1, 1},
+	"hmget":        {-3, "readonly fast", 1, 1, 1},
+	"hdel":         {-3, "write fast", 1, 1, 1},
+	"hlen":         {2, "readonly fast", 1, 1, 1},
+	"hstrlen":      {3, "readonly fast", 1, 1, 1},
+	"hkeys":        {2, "readonly", 1, 1, 1},
+	"hvals":        {2, "readonly", 1, 1, 1},
+	"hgetall":      {2, "readonly", 1, 1, 1},
+	"hexists":      {3, "readonly fast", 1, 1, 1},
+	"hincrby":      {4, "write denyoom fast", 1, 1, 1},
+	"hincrbyfloat": {4, "write denyoom fast", 1, 1, 1},
+	"hrandfield":   {-2, "readonly", 1, 1, 1},
+	"hscan":        {-3, "readonly", 1, 1, 1},
+
+	// sorted sets
+	"zadd":             {-4, "write denyoom fast", 1, 1, 1},
+	"zincrby":          {4, "write denyoom fast", 1, 1, 1},
+	"zrem":             {-3, "write fast", 1, 1, 1},
+	"zcard":            {2, "readonly fast", 1, 1, 1},
+	"zscore":           {3, "readonly fast", 1, 1, 1},
+	"zmscore":          {-3, "readonly fast", 1, 1, 1},
+	"zrank":            {3, "readonly fast", 1, 1, 1},
+	"zrevrank":         {3, "readonly fast", 1, 1, 1},
+	"zrange":           {-4, "readonly", 1, 1, 1},
+	"zrangestore":      {-5, "write denyoom", 1, 2, 1},
+	"zrevrange":        {-4, "readonly", 1, 1, 1},
+	"zrangebyscore":    {-4, "readonly", 1, 1, 1},
+	"zrevrangebyscore": {-4, "readonly", 1, 1, 1},
+	"zrangebylex":      {-4, "readonly", 1, 1, 1},
+	"zrevrangebylex":   {-4, "readonly", 1, 1, 1},
+	"zcount":           {4, "readonly fast", 1, 1, 1},
+	"zlexcount":        {4, "readonly fast", 1, 1, 1},
+	"zremrangebyrank":  {4, "write", 1, 1, 1},
+	"zremrangebyscore": {4, "write", 1, 1, 1},
+	"zremrangebylex":   {4, "write", 1, 1, 1},
+	"zpopmin":          {-2, "write fast", 1, 1, 1},
+	"zpopmax":          {-2, "write fast", 1, 1, 1},
+	"bzpopmin":         {-3, "write noscript blocking fast", 1, -2, 1},
+	"bzpopmax":         {-3, "write noscript blocking fast", 1, -2, 1},
+	"zrandmember":      {-2, "readonly", 1, 1, 1},
+	"zscan":            {-3, "readonly", 1, 1, 1},
+	"zunionstore":      {-4, "write denyoom movablekeys", 1, 1, 1},
+	"zinterstore":      {-4, "write denyoom movablekeys", 1, 1, 1},
+	"zdiffstore":       {-4, "write denyoom movablekeys", 1, 1, 1},
+	"zunion":           {-3, "readonly movablekeys", 0, 0, 0},
+	"zinter":           {-3, "readonly movablekeys", 0, 0, 0},
+	"zdiff":            {-3, "readonly movablekeys", 0, 0, 0},
+	"zintercard":       {-3, "readonly movablekeys", 0, 0, 0},
+	"zmpop":            {-4, "write movablekeys", 0, 0, 0},
+	"bzmpop":           {-5, "write blocking movablekeys", 0, 0, 0},
+
+	// hyperloglog
+	"pfadd":   {-2, "write denyoom fast", 1, 1, 1},
+	"pfcount": {-2, "readonly", 1, -1, 1},
+	"pfmerge": {-2, "write denyoom", 1, -1, 1},
+
+	// geo
+	"geoadd":               {-5, "write denyoom", 1, 1, 1},
+	"geodist":              {-4, "readonly", 1, 1, 1},
+	"geohash":              {-2, "readonly", 1, 1, 1},
+	"geopos":               {-2, "readonly", 1, 1, 1},
+	"georadius":            {-6, "write denyoom movablekeys", 1, 1, 1},
+	"georadius_ro":         {-6, "readonly", 1, 1, 1},
+	"georadiusbymember":    {-5, "write denyoom movablekeys", 1, 1, 1},
+	"georadiusbymember_ro": {-5, "readonly", 1, 1, 1},
+	"geosearch":            {-7, "readonly", 1, 1, 1},
+	"geosearchstore":       {-8, "write denyoom", 1, 2, 1},
+
+	// streams
+	"xadd":       {-5, "write denyoom fast", 1, 1, 1},
+	"xlen":       {2, "readonly fast", 1, 1, 1},
+	"xrange":     {-4, "readonly", 1, 1, 1},
+	"xrevrange":  {-4, "readonly", 1, 1, 1},
+	"xdel":       {-3, "write fast", 1, 1, 1},
+	"xtrim":      {-4, "write", 1, 1, 1},
+	"xread":      {-4, "readonly blocking movablekeys", 0, 0, 0},
+	"xreadgroup": {-7, "write blocking movablekeys", 0, 0, 0},
+	"xack":       {-4, "write fast", 1, 1, 1},
+	"xpending":   {-3, "readonly", 1, 1, 1},
+	"xclaim":     {-6, "write fast", 1, 1, 1},
+	"xautoclaim": {-6, "write fast", 1, 1, 1},
+	"xsetid":     {-3, "write denyoom fast", 1, 1, 1},
+	"xgroup":     {-2, "", 0, 0, 0},
+	"xinfo":      {-2, "", 0, 0, 0},
+
+	// scripting and functions
+	"eval":       {-3, "noscript skip_monitor may_replicate no_mandatory_keys stale movablekeys", 0, 0, 0},
+	"evalsha":    {-3, "noscript skip_monitor may_replicate no_mandatory_keys stale movablekeys", 0, 0, 0},
+	"eval_ro":    {-3, "readonly noscript skip_monitor no_mandatory_keys stale movablekeys", 0, 0, 0},
+	"evalsha_ro": {-3, "readonly noscript skip_monitor no_mandatory_keys stale movablekeys", 0, 0, 0},
+	"fcall":      {-3, "noscript skip_monitor may_replicate no_mandatory_keys stale movablekeys", 0, 0, 0},
+	"fcall_ro":   {-3, "readonly noscript skip_monitor no_mandatory_keys stale movablekeys", 0, 0, 0},
+	"script":     {-2, "", 0, 0, 0},
+	"function":   {-2, "", 0, 0, 0},
+
+	// pub/sub
+	"publish":      {3, "pubsub loading stale fast may_replicate", 0, 0, 0},
+	"spublish":     {3, "pubsub loading stale fast may_replicate", 1, 1, 1},
+	"subscribe":    {-2, "pubsub noscript loading stale", 0, 0, 0},
+	"psubscribe":   {-2, "pubsub noscript loading stale", 0, 0, 0},
+	"ssubscribe":   {-2, "pubsub noscript loading stale", 1, -1, 1},
+	"unsubscribe":  {-1, "pubsub noscript loading stale", 0, 0, 0},
+	"punsubscribe": {-1, "pubsub noscript loading stale", 0, 0, 0},
+	"sunsubscribe": {-1, "pubsub noscript loading stale", 1, -1, 1},
+	"pubsub":       {-2, "", 0, 0, 0},
+
+	// transactions
+	"multi":   {1, "noscript loading stale fast allow_busy", 0, 0, 0},
+	"exec":    {1, "noscript loading stale skip_slowlog", 0, 0, 0},
+	"discard": {1, "noscript loading stale fast allow_busy", 0, 0, 0},
+	"watch":   {-2, "noscript loading stale fast allow_busy", 1, -1, 1},
+	"unwatch": {1, "noscript loading stale fast allow_busy", 0, 0, 0},
+
+	// connection and server
+	"ping":         {-1, "fast", 0, 0, 0},
+	"echo":         {2, "fast", 0, 0, 0},
+	"quit":         {-1, "noscript loading stale fast no_auth allow_busy", 0, 0, 0},
+	"auth":         {-2, "noscript loading stale fast no_auth allow_busy", 0, 0, 0},
+	"hello":        {-1, "noscript loading stale fast no_auth allow_busy", 0, 0, 0},
+	"select":       {2, "loading stale fast", 0, 0, 0},
+	"swapdb":       {3, "write fast", 0, 0, 0},
+	"reset":        {1, "noscript loading stale fast no_auth allow_busy", 0, 0, 0},
+	"client":       {-2, "", 0, 0, 0},
+	"command":      {-1, "loading stale", 0, 0, 0},
+	"config":       {-2, "", 0, 0, 0},
+	"acl":          {-2, "", 0, 0, 0},
+	"cluster":      {-2, "", 0, 0, 0},
+	"memory":       {-2, "", 0, 0, 0},
+	"latency":      {-2, "", 0, 0, 0},
+	"slowlog":      {-2, "", 0, 0, 0},
+	"module":       {-2, "", 0, 0, 0},
+	"debug":        {-2, "admin noscript loading stale", 0, 0, 0},
+	"readonly":     {1, "loading stale fast", 0, 0, 0},
+	"readwrite":    {1, "loading stale fast", 0, 0, 0},
+	"asking":       {1, "fast", 0, 0, 0},
+	"dbsize":       {1, "readonly fast", 0, 0, 0},
+	"flushall":     {-1, "write", 0, 0, 0},
+	"flushdb":      {-1, "write", 0, 0, 0},
+	"info":         {-1, "loading stale", 0, 0, 0},
+	"lastsave":     {1, "loading stale fast", 0, 0, 0},
+	"save":         {1, "admin noscript", 0, 0, 0},
+	"bgsave":       {-1, "admin noscript", 0, 0, 0},
+	"bgrewriteaof": {1, "admin noscript", 0, 0, 0},
+	"shutdown":     {-1, "admin noscript loading stale no_multi allow_busy", 0, 0, 0},
+	"slaveof":      {3, "admin noscript stale no_async_loading", 0, 0, 0},
+	"replicaof":    {3, "admin noscript stale no_async_loading", 0, 0, 0},
+	"failover":     {-1, "admin noscript stale", 0, 0, 0},
+	"role":         {1, "noscript loading stale fast", 0, 0, 0},
+	"time":         {1, "loading stale fast", 0, 0, 0},
+	"lolwut":       {-1, "readonly fast", 0, 0, 0},
+	"monitor":      {1, "admin noscript loading stale", 0, 0, 0},
+	"sync":         {1, "admin noscript no_async_loading no_multi", 0, 0, 0},
+	"psync":        {-3, "admin noscript no_async_loading no_multi", 0, 0, 0},
+}
+
+// commandsInfo returns the reply of COMMAND built from commandSpecs.
+func commandsInfo() map[string]*redis.CommandInfo {
+	infos := make(map[string]*redis.CommandInfo, len(commandSpecs))
+	for name, spec := range commandSpecs {
+		flags := strings.Fields(spec.flags)
+		info := &redis.CommandInfo{
+			Name:        name,
+			Arity:       spec.arity,
+			Flags:       flags,
+			FirstKeyPos: spec.first,
+			LastKeyPos:  spec.last,
+			StepCount:   spec.step,
+		}
+		for _, flag := range flags {
+			if flag == "readonly" {
+				info.ReadOnly = true
+			}
+		}
+		infos[name] = info
+	}
+	return infos
+}
+
+// isWriteCmd reports whether COMMAND flags cmd as a write command.
+func isWriteCmd(cmd redis.Cmder) bool {
+	for _, flag := range strings.Fields(commandSpecs[cmd.Name()].flags) {
+		if flag == "write" {
+			return true
+		}
+	}
+	return false
+}
+
+// firstKeyPos returns the position of the key a ClusterClient routes cmd by, the same way
+// as go-redis does. It is 0 if cmd has no key, the command is sent to a random slot.
+func firstKeyPos(cmd redis.Cmder) int {
+	if pos, ok := cmdKeyPos(cmd); ok && pos != 0 {
+		return pos
+	}
+
+	switch cmd.Name() {
+	case "eval", "evalsha", "eval_ro", "evalsha_ro":
+		if argString(cmd, 2) != "0" {
+			return 3
+		}
+		return 0
+	case "publish":
+		return 1
+	case "memory":
+		if argString(cmd, 1) == "usage" {
+			return 2
+		}
+	}
+
+	if spec, ok := commandSpecs[cmd.Name()]; ok {
+		return int(spec.first)
+	}
+	return 1
+}
+
+// cmdKeyPos returns the key position set with SetFirstKeyPos, the commands of go-redis set it
+// when the key does not follow the command name. go-redis has no getter for it, it is read from
+// the unexported keyPos field: ok is false if the field is missing or has another type, firstKeyPos
+// then uses the key positions of commandSpecs.
+func cmdKeyPos(cmd redis.Cmder) (pos int, ok bool) {
+	v := reflect.ValueOf(cmd)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return 0, false
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return 0, false
+	}
+	f := v.FieldByName("keyPos")
+	switch f.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(f.Int()), true
+	}
+	return 0, false
+}
+
+// argString returns the argument at pos as go-redis writes it, or an empty string.
+func argString(cmd redis.Cmder, pos int) string {
+	args := cmd.Args()
+	if pos >= len(args) {
+		return ""
+	}
+	return wireString(args[pos])
+}
+
+// cmdKeys returns the keys of cmd, found with the key positions of commandSpecs and, for the
+// commands flagged movablekeys and the subcommands of container commands, with their syntax.
+func cmdKeys(cmd redis.Cmder) []string {
+	args := cmd.Args()
+	name := cmd.Name()
+
+	// numKeysAt returns the keys following the number of keys at pos
+	numKeysAt := func(pos int) []string {
+		n, err := strconv.Atoi(argString(cmd, pos))
+		if err != nil || n <= 0 {
+			return nil
+		}
+		return argStrings(cmd, pos+1, pos+n, 1)
+	}
+
+	switch name {
+	case "eval", "evalsha", "eval_ro", "evalsha_ro", "fcall", "fcall_ro":
+		return numKeysAt(2)
+	case "zunionstore", "zinterstore", "zdiffstore":
+		return append(argStrings(cmd, 1, 1, 1), numKeysAt(2)...)
+	case "zunion", "zinter", "zdiff", "zintercard", "sintercard", "lmpop", "zmpop":
+		return numKeysAt(1)
+	case "blmpop", "bzmpop":
+		return numKeysAt(2)
+	case "xread", "xreadgroup":
+		for i := 1; i < len(args); i++ {
+			if strings.EqualFold(argString(cmd, i), "streams") {
+				n := (len(args) - i - 1) / 2
+				return argStrings(cmd, i+1, i+n, 1)
+			}
+		}
+		return nil
+	case "sort", "georadius", "georadiusbymember":
+		keys := argStrings(cmd, 1, 1, 1)
+		for i := 2; i < len(args)-1; i++ {
+			switch strings.ToLower(argString(cmd, i)) {
+			case "store", "storedist":
+				keys = append(keys, argString(cmd, i+1))
+			}
+		}
+		return keys
+	case "migrate":
+		if key := argString(cmd, 3); key != "" {
+			return []string{key}
+		}
+		for i := 6; i < len(args); i++ {
+			if strings.EqualFold(argString(cmd, i), "keys") {
+				return argStrings(cmd, i+1, len(args)-1, 1)
+			}
+		}
+		return nil
+	case "object", "xinfo", "xgroup":
+		switch subCommand(cmd) {
+		case "encoding", "freq", "idletime", "refcount", "stream", "groups", "consumers",
+			"create", "createconsumer", "delconsumer", "destroy", "setid":
+			return argStrings(cmd, 2, 2, 1)
+		}
+		return nil
+	case "memory":
+		if subCommand(cmd) == "usage" {
+			return argStrings(cmd, 2, 2, 1)
+		}
+		return nil
+	}
+
+	spec, ok := commandSpecs[name]
+	if !ok || spec.first == 0 {
+		return nil
+	}
+	last := int(spec.last)
+	if last < 0 {
+		last += len(args)
+	}
+	return argStrings(cmd, int(spec.first), last, int(spec.step))
+}
+
+// argStrings returns the arguments from first to last (inclusive) every step arguments.
+func argStrings(cmd redis.Cmder, first, last, step int) []string {
+	if n := len(cmd.Args()); last >= n {
+		last = n - 1
+	}
+	var ss []string
+	for i := first; i <= last; i += step {
+		ss = append(ss, argString(cmd, i))
+	}
+	return ss
+}
+
+// argSpan is a range [start, end) of arguments made of groups of size arguments
+// whose order does not change the meaning of the command.
+type argSpan struct {
+	start, end, size int
+}
+
+// argShapes returns the unordered spans of the arguments of a command, such as the members
+// of SADD or the field-value pairs of HSET.
+var argShapes = map[string]func(args []interface{}) []argSpan{
+	"mset":   spanFrom(1, 2),
+	"msetnx": spanFrom(1, 2),
+	"hset":   spanFrom(2, 2),
+	"hmset":  spanFrom(2, 2),
+	"hdel":   spanFrom(2, 1),
+	"hmget":  spanFrom(2, 1),
+	"sadd":   spanFrom(2, 1),
+	"srem":   spanFrom(2, 1),
+	"zrem":   spanFrom(2, 1),
+	"del":    spanFrom(1, 1),
+	"unlink": spanFrom(1, 1),
+	"exists": spanFrom(1, 1),
+	"touch":  spanFrom(1, 1),
+	"zadd":   zaddSpans,
+	"xadd":   xaddSpans,
+}
+
+// argSpans returns the unordered spans of args, the arguments of the command name.
+func argSpans(name string, args []interface{}) []argSpan {
+	shape, ok := argShapes[strings.ToLower(name)]
+	if !ok {
+		return nil
+	}
+	var spans []argSpan
+	for _, span := range shape(args) {
+		if span.start < span.end && span.end <= len(args) && (span.end-span.start)%span.size == 0 {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+func spanFrom(start, size int) func(args []interface{}) []argSpan {
+	return func(args []interface{}) []argSpan {
+		return []argSpan{{start: start, end: len(args), size: size}}
+	}
+}
+
+// zaddSpans returns the score-member pairs following the options of ZADD.
+func zaddSpans(args []interface{}) []argSpan {
+	i := 2
+	for ; i < len(args); i++ {
+		switch strings.ToLower(wireString(args[i])) {
+		case "nx", "xx", "gt", "lt", "ch", "incr":
+			continue
+		}
+		break
+	}
+	return []argSpan{{start: i, end: len(args), size: 2}}
+}
+
+// xaddSpans returns the field-value pairs following the options and the ID of XADD.
+func xaddSpans(args []interface{}) []argSpan {
+	i := 2
+	for i < len(args) {
+		switch strings.ToLower(wireString(args[i])) {
+		case "nomkstream":
+			i++
+			continue
+		case "maxlen", "minid":
+			i++
+			if s := wireString(arg(args, i)); s == "=" || s == "~" {
+				i++
+			}
+			i++
+			if strings.ToLower(wireString(arg(args, i))) == "limit" {
+				i += 2
+			}
+			continue
+		}
+		break
+	}
+	// skip the ID
+	return []argSpan{{start: i + 1, end: len(args), size: 2}}
+}
+
+func arg(args []interface{}, pos int) interface{} {
+	if pos < len(args) {
+		return args[pos]
+	}
+	return nil
+}
+
+// wireString returns v as go-redis writes it.
+func wireString(v interface{}) string {
+	s, err := wireArg(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return s
+}
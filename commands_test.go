@@ -1,6 +1,7 @@
 package redismock
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
@@ -25,6 +26,17 @@ var _ = Describe("Commands", func() {
 		return d
 	}
 
+	do := func(args ...interface{}) *redis.Cmd {
+		switch clientType {
+		case redisClient:
+			return client.(*redis.Client).Do(ctx, args...)
+		case redisCluster:
+			return client.(*redis.ClusterClient).Do(ctx, args...)
+		default:
+			panic("Do: unsupported client type")
+		}
+	}
+
 	callCommandTest := func() {
 		It("Do", func() {
 			operationCmdCmd(clientMock, func() *ExpectedCmd {
@@ -2738,19 +2750,29 @@ var _ = Describe("Commands", func() {
 		})
 
 		It("ClusterKeySlot", func() {
-			operationIntCmd(clientMock, func() *ExpectedInt {
-				return clientMock.ExpectClusterKeySlot("key")
-			}, func() *redis.IntCmd {
-				return client.ClusterKeySlot(ctx, "key")
-			})
+			clientMock.ExpectClusterKeySlot("key").SetErr(errors.New("int cmd error"))
+			Expect(client.ClusterKeySlot(ctx, "key").Err()).To(MatchError("int cmd error"))
+
+			// answers the slot of the key without value
+			clientMock.ExpectClusterKeySlot("key")
+			Expect(client.ClusterKeySlot(ctx, "key").Result()).To(Equal(int64(12539)))
+
+			clientMock.ExpectClusterKeySlot("key").SetVal(1024)
+			Expect(client.ClusterKeySlot(ctx, "key").Result()).To(Equal(int64(1024)))
 		})
 
 		It("ClusterGetKeysInSlot", func() {
-			operationStringSliceCmd(clientMock, func() *ExpectedStringSlice {
-				return clientMock.ExpectClusterGetKeysInSlot(1, 2)
-			}, func() *redis.StringSliceCmd {
-				return client.ClusterGetKeysInSlot(ctx, 1, 2)
-			})
+			clientMock.ExpectClusterGetKeysInSlot(1, 2).SetErr(errors.New("string slice cmd error"))
+			Expect(client.ClusterGetKeysInSlot(ctx, 1, 2).Err()).To(MatchError("string slice cmd error"))
+
+			// answers the keys of the expectations in the slot without value
+			clientMock.ExpectGet("key").RedisNil()
+			clientMock.ExpectClusterGetKeysInSlot(12539, 2)
+			Expect(client.Get(ctx, "key").Err()).To(Equal(redis.Nil))
+			Expect(client.ClusterGetKeysInSlot(ctx, 12539, 2).Result()).To(Equal([]string{"key"}))
+
+			clientMock.ExpectClusterGetKeysInSlot(1, 2).SetVal([]string{"redis", "move"})
+			Expect(client.ClusterGetKeysInSlot(ctx, 1, 2).Result()).To(Equal([]string{"redis", "move"}))
 		})
 
 		It("ClusterCountFailureReports", func() {
@@ -3102,6 +3124,32 @@ var _ = Describe("Commands", func() {
 			})
 		})
 
+		It("FunctionStats", func() {
+			operationFunctionStatsCmd(clientMock, func() *ExpectedFunctionStats {
+				return clientMock.ExpectFunctionStats()
+			}, func() *redis.Cmd {
+				return do("function", "stats")
+			})
+		})
+
+		It("FCall", func() {
+			Expect(clientMock.PreloadFunctions("#!lua name=lib\nredis.register_function('fn', function() end)")).NotTo(HaveOccurred())
+			operationCmdCmd(clientMock, func() *ExpectedCmd {
+				return &clientMock.ExpectFCall("fn", []string{"key1", "key2"}, "args1", "args2").ExpectedCmd
+			}, func() *redis.Cmd {
+				return do("fcall", "fn", 2, "key1", "key2", "args1", "args2")
+			})
+		})
+
+		It("FCallRO", func() {
+			Expect(clientMock.PreloadFunctions("#!lua name=lib\nredis.register_function('fn', function() end)")).NotTo(HaveOccurred())
+			operationCmdCmd(clientMock, func() *ExpectedCmd {
+				return &clientMock.ExpectFCallRO("fn", []string{"key1"}, "args1").ExpectedCmd
+			}, func() *redis.Cmd {
+				return do("fcall_ro", "fn", 1, "key1", "args1")
+			})
+		})
+
 		// ------------------------------------------------------------------
 
 		It("ACLDryRun", func() {
@@ -0,0 +1,529 @@
+package redismock
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Commands sent by a redis.Conn (client.Conn()) do not run the hooks of the client,
+// they are written to a connection created by Options.Dialer. The mock dials an in-memory
+// connection and serves the RESP protocol on it, every command is answered by the
+// expectations of the scope the connection is bound to, see ExpectConn.
+//
+// go-redis only passes the context of a Conn command to its hooks, the hook returned by
+// ConnHook hands it over to the connection, see connContext.
+
+// connScopes are the ExpectConn scopes waiting for a connection, shared by all clones of a mock.
+type connScopes struct {
+	mu     sync.Mutex
+	scopes []*mock
+	next   int
+}
+
+func (c *connScopes) add(scope *mock) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scopes = append(c.scopes, scope)
+}
+
+// claim binds a new connection to the next ExpectConn scope, nil if there is none.
+func (c *connScopes) claim() *mock {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.next >= len(c.scopes) {
+		return nil
+	}
+	scope := c.scopes[c.next]
+	c.next++
+	return scope
+}
+
+func (c *connScopes) all() []*mock {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*mock(nil), c.scopes...)
+}
+
+func (c *connScopes) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scopes, c.next = nil, 0
+}
+
+// wireCmd is a command read from a connection, its args are the strings sent by go-redis.
+// Expectations write their reply into it with inflow, the fields next to Cmd.val catch the
+// values of the Cmd types replying more than one value.
+type wireCmd struct {
+	*redis.Cmd
+
+	page      interface{}
+	cursor    interface{}
+	start     interface{}
+	key       interface{}
+	locations interface{}
+}
+
+// reply returns the value of cmd, as redis-server sends it.
+func (cmd *wireCmd) reply() interface{} {
+	switch {
+	case cmd.cursor != nil:
+		return []interface{}{strconv.FormatUint(cmd.cursor.(uint64), 10), cmd.page}
+	case cmd.start != nil:
+		return []interface{}{cmd.start, cmd.Val(), []interface{}{}}
+	case cmd.key != nil:
+		return []interface{}{cmd.key, cmd.Val()}
+	case cmd.locations != nil:
+		return cmd.locations
+	}
+	return cmd.Val()
+}
+
+func (m *mock) dial(ctx context.Context, _, _ string) (net.Conn, error) {
+	scope := m
+	if ctx.Value(handshakeKey{}) == nil {
+		if s := m.conns.claim(); s != nil {
+			scope = s
+		}
+	}
+
+	cc, _ := ctx.Value(connContextKey{}).(*connContext)
+
+	client, server := net.Pipe()
+	go scope.serve(server, cc)
+	return client, nil
+}
+
+// connContext is the context of the command sent on a Conn, set by its hook before
+// the command is written to the connection. A Conn sends one command or pipeline at a time.
+type connContext struct {
+	mu  sync.Mutex
+	ctx context.Context
+}
+
+// connContextKey passes the connContext of a Conn to the dial of its connection.
+type connContextKey struct{}
+
+func (c *connContext) set(ctx context.Context) context.Context {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ctx = ctx
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// get returns the context of the command being served, context.Background if the Conn has no hook.
+func (c *connContext) get() context.Context {
+	if c == nil {
+		return context.Background()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ctx
+}
+
+func (c *connContext) DialHook(hook redis.DialHook) redis.DialHook {
+	return hook
+}
+
+func (c *connContext) ProcessHook(hook redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		return hook(c.set(ctx), cmd)
+	}
+}
+
+func (c *connContext) ProcessPipelineHook(hook redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		return hook(c.set(ctx), cmds)
+	}
+}
+
+func (m *mock) ConnHook() redis.Hook {
+	return &connContext{}
+}
+
+// serve answers the commands of one connection until it is closed, cc holds the context of the commands.
+func (m *mock) serve(conn net.Conn, cc *connContext) {
+	defer conn.Close()
+
+	out := newReplyWriter(conn)
+	defer out.close()
+
+	// the keys watched by the connection
+	watch := m.watches.add()
+	defer m.watches.remove(watch)
+
+	rd := bufio.NewReader(conn)
+	var tx []redis.Cmder
+	for n := 0; ; n++ {
+		args, err := readCommand(rd)
+		if err != nil {
+			return
+		}
+		cmd := newWireCmd(args)
+		ctx := context.WithValue(cc.get(), watchKey{}, watch)
+
+		switch {
+		case n == 0 && cmd.Name() == "hello" && m.handshake == nil:
+			// connection init by go-redis
+			out.write(encodeReply(cmd, helloReply(), nil))
+		case cmd.Name() == handshakeCmd:
+			out.write([]byte("+OK\r\n"))
+		case tx != nil || cmd.Name() == "multi":
+			tx = append(tx, cmd)
+			if cmd.Name() == "exec" || cmd.Name() == "discard" {
+				out.write(m.serveTx(ctx, tx))
+				tx = nil
+			}
+		default:
+			err := m.process(ctx, cmd)
+			out.write(encodeReply(cmd, cmd.reply(), err))
+		}
+	}
+}
+
+// serveTx answers MULTI, the queued commands and EXEC the way redis-server does.
+func (m *mock) serveTx(ctx context.Context, cmds []redis.Cmder) []byte {
+	var buf []byte
+
+	last := cmds[len(cmds)-1]
+	if last.Name() == "discard" {
+		buf = append(buf, "+OK\r\n"...)
+		for range cmds[1 : len(cmds)-1] {
+			buf = append(buf, "+QUEUED\r\n"...)
+		}
+		return append(buf, "+OK\r\n"...)
+	}
+
+	_ = m.processTxPipeline(ctx, cmds)
+
+	multi, exec := cmds[0], cmds[len(cmds)-1]
+	if err := multi.Err(); err != nil {
+		return encodeReply(multi, nil, err)
+	}
+	buf = append(buf, "+OK\r\n"...)
+	for range cmds[1 : len(cmds)-1] {
+		buf = append(buf, "+QUEUED\r\n"...)
+	}
+
+	if err := exec.Err(); err != nil {
+		if err == redis.TxFailedErr {
+			return append(buf, "*-1\r\n"...)
+		}
+		return append(buf, encodeReply(exec, nil, err)...)
+	}
+
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(cmds)-2), 10)
+	buf = append(buf, "\r\n"...)
+	for _, cmd := range cmds[1 : len(cmds)-1] {
+		buf = append(buf, encodeReply(cmd, cmd.(*wireCmd).reply(), cmd.Err())...)
+	}
+	return buf
+}
+
+// handshakeState tracks whether the connection of the client commands was initialised,
+// shared by all clones of a mock.
+type handshakeState struct {
+	mu   sync.Mutex
+	done bool
+}
+
+// handshakeKey marks the context of the connection init run by connect.
+type handshakeKey struct{}
+
+// handshakeCmd is sent once the connection init succeeded, it is answered without
+// being matched against the expectations.
+const handshakeCmd = "redismock.handshake"
+
+// connect initialises the connection of the client commands, the way go-redis initialises a
+// pooled connection before its first use. The commands of the client are processed by the
+// hooks and never use a pooled connection, so the mock dials one for the init: the handshake
+// and OnConnect are matched against the expectations of the mock in the order they are sent.
+// Like a failed init in go-redis, a failed connect is tried again by the next command.
+func (m *mock) connect(ctx context.Context) error {
+	if ctx.Value(handshakeKey{}) != nil {
+		// a command of OnConnect sent through the client
+		return nil
+	}
+
+	h := m.handshake
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.done {
+		return nil
+	}
+
+	conn := m.client.(*redis.Client).Conn()
+	conn.AddHook(m.ConnHook())
+	defer conn.Close()
+
+	ctx = context.WithValue(ctx, handshakeKey{}, true)
+	if err := conn.Process(ctx, redis.NewCmd(ctx, handshakeCmd)); err != nil {
+		return err
+	}
+	h.done = true
+	return nil
+}
+
+func newWireCmd(args []string) *wireCmd {
+	cmdArgs := make([]interface{}, len(args))
+	for i, arg := range args {
+		cmdArgs[i] = arg
+	}
+	return &wireCmd{Cmd: redis.NewCmd(context.Background(), cmdArgs...)}
+}
+
+// helloReply is the HELLO reply of go-redis connection init, when it is not expected.
+func helloReply() map[string]interface{} {
+	return map[string]interface{}{
+		"server":  "redis",
+		"version": "7.0.0",
+		"proto":   int64(3),
+		"mode":    "standalone",
+		"role":    "master",
+		"modules": []interface{}{},
+	}
+}
+
+// readCommand reads a command sent by go-redis, an array of bulk strings.
+func readCommand(rd *bufio.Reader) ([]string, error) {
+	line, err := readLine(rd)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return nil, fmt.Errorf("redismock: expected array, got %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := readLine(rd)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("redismock: expected bulk string, got %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, size+2)
+		if _, err := io.ReadFull(rd, b); err != nil {
+			return nil, err
+		}
+		args[i] = string(b[:size])
+	}
+	return args, nil
+}
+
+func readLine(rd *bufio.Reader) (string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
+
+// encodeReply writes the reply of cmd in RESP, go-redis reads it into the Cmd type of the caller.
+func encodeReply(cmd redis.Cmder, val interface{}, err error) []byte {
+	if err != nil {
+		if err == redis.Nil {
+			return []byte("_\r\n")
+		}
+		return []byte("-" + oneLine(err.Error()) + "\r\n")
+	}
+
+	buf, err := appendValue(nil, cmd.Name(), val)
+	if err != nil {
+		return []byte("-ERR " + oneLine(err.Error()) + "\r\n")
+	}
+	return buf
+}
+
+func appendValue(buf []byte, name string, val interface{}) ([]byte, error) {
+	switch v := val.(type) {
+	case nil:
+		return append(buf, "_\r\n"...), nil
+	case error:
+		if v == redis.Nil {
+			return append(buf, "_\r\n"...), nil
+		}
+		return append(buf, "-"+oneLine(v.Error())+"\r\n"...), nil
+	case string:
+		return appendBulk(buf, v), nil
+	case []byte:
+		return appendBulk(buf, string(v)), nil
+	case bool:
+		if v {
+			return append(buf, ":1\r\n"...), nil
+		}
+		return append(buf, ":0\r\n"...), nil
+	case float32:
+		return appendBulk(buf, strconv.FormatFloat(float64(v), 'f', -1, 64)), nil
+	case float64:
+		return appendBulk(buf, strconv.FormatFloat(v, 'f', -1, 64)), nil
+	case time.Duration:
+		// DurationCmd reads the unit of the command, -1 and -2 are sent as they are
+		if v < 0 {
+			return appendInt(buf, int64(v)), nil
+		}
+		switch name {
+		case "pttl", "pexpiretime":
+			return appendInt(buf, int64(v/time.Millisecond)), nil
+		}
+		return appendInt(buf, int64(v/time.Second)), nil
+	case []redis.Z:
+		// ZSliceCmd reads member and score pairs from a flat array
+		buf = append(buf, '*')
+		buf = strconv.AppendInt(buf, int64(2*len(v)), 10)
+		buf = append(buf, "\r\n"...)
+		for _, z := range v {
+			member, err := wireArg(z.Member)
+			if err != nil {
+				return nil, err
+			}
+			buf = appendBulk(buf, member)
+			buf = appendBulk(buf, strconv.FormatFloat(z.Score, 'f', -1, 64))
+		}
+		return buf, nil
+	case redis.XMessage:
+		values := make([]interface{}, 0, 2*len(v.Values))
+		for _, field := range sortedKeys(v.Values) {
+			values = append(values, field, v.Values[field])
+		}
+		buf = append(buf, "*2\r\n"...)
+		buf = appendBulk(buf, v.ID)
+		return appendValue(buf, name, values)
+	case time.Time:
+		// TIME replies seconds and microseconds
+		buf = append(buf, "*2\r\n"...)
+		buf = appendBulk(buf, strconv.FormatInt(v.Unix(), 10))
+		return appendBulk(buf, strconv.FormatInt(int64(v.Nanosecond()/1000), 10)), nil
+	}
+
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendInt(buf, rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return appendInt(buf, int64(rv.Uint())), nil
+	case reflect.Slice:
+		buf = append(buf, '*')
+		buf = strconv.AppendInt(buf, int64(rv.Len()), 10)
+		buf = append(buf, "\r\n"...)
+		var err error
+		for i := 0; i < rv.Len(); i++ {
+			if buf, err = appendValue(buf, name, rv.Index(i).Interface()); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			break
+		}
+		keys := make([]string, 0, rv.Len())
+		for _, k := range rv.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		sort.Strings(keys)
+
+		buf = append(buf, '%')
+		buf = strconv.AppendInt(buf, int64(len(keys)), 10)
+		buf = append(buf, "\r\n"...)
+		var err error
+		for _, k := range keys {
+			buf = appendBulk(buf, k)
+			if buf, err = appendValue(buf, name, rv.MapIndex(reflect.ValueOf(k).Convert(rv.Type().Key())).Interface()); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+	return nil, fmt.Errorf("redismock: %T reply can not be sent on a connection", val)
+}
+
+func appendBulk(buf []byte, s string) []byte {
+	buf = append(buf, '$')
+	buf = strconv.AppendInt(buf, int64(len(s)), 10)
+	buf = append(buf, "\r\n"...)
+	buf = append(buf, s...)
+	return append(buf, "\r\n"...)
+}
+
+func appendInt(buf []byte, n int64) []byte {
+	buf = append(buf, ':')
+	buf = strconv.AppendInt(buf, n, 10)
+	return append(buf, "\r\n"...)
+}
+
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+// replyWriter sends replies from its own goroutine, net.Pipe is unbuffered and go-redis
+// writes a whole pipeline before it reads the first reply.
+type replyWriter struct {
+	conn net.Conn
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending [][]byte
+	closed  bool
+}
+
+func newReplyWriter(conn net.Conn) *replyWriter {
+	w := &replyWriter{conn: conn}
+	w.cond = sync.NewCond(&w.mu)
+	go w.run()
+	return w
+}
+
+func (w *replyWriter) write(b []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending = append(w.pending, b)
+	w.cond.Signal()
+}
+
+func (w *replyWriter) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	w.cond.Signal()
+}
+
+func (w *replyWriter) run() {
+	for {
+		w.mu.Lock()
+		for len(w.pending) == 0 && !w.closed {
+			w.cond.Wait()
+		}
+		if len(w.pending) == 0 {
+			w.mu.Unlock()
+			return
+		}
+		b := w.pending[0]
+		w.pending = w.pending[1:]
+		w.mu.Unlock()
+
+		if _, err := w.conn.Write(b); err != nil {
+			return
+		}
+	}
+}
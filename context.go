@@ -0,0 +1,77 @@
+package redismock
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Call is a command received by the mock, with the context it was issued with.
+type Call struct {
+	Ctx context.Context
+	Cmd redis.Cmder
+}
+
+// callLog records the commands received by a mock, shared by all clones of a mock.
+type callLog struct {
+	mu    sync.Mutex
+	calls []Call
+}
+
+func (l *callLog) record(ctx context.Context, cmds ...redis.Cmder) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, cmd := range cmds {
+		l.calls = append(l.calls, Call{Ctx: ctx, Cmd: cmd})
+	}
+}
+
+func (l *callLog) list() []Call {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Call(nil), l.calls...)
+}
+
+// HasDeadlineWithin checks that the context has a deadline at most d from now, see WithContext.
+func HasDeadlineWithin(d time.Duration) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			return fmt.Errorf("context has no deadline, expectation deadline within %s", d)
+		}
+		if left := time.Until(deadline); left > d {
+			return fmt.Errorf("context deadline in %s, expectation deadline within %s", left, d)
+		}
+		return nil
+	}
+}
+
+// HasValue checks that the value of key in the context is val, see WithContext.
+func HasValue(key, val interface{}) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if v := ctx.Value(key); !reflect.DeepEqual(v, val) {
+			return fmt.Errorf("context value of '%v' is '%+v', expectation '%+v'", key, v, val)
+		}
+		return nil
+	}
+}
+
+// matchContext checks the context of cmd with the context checks of expect.
+func matchContext(ctx context.Context, expect expectation, cmd redis.Cmder) error {
+	for _, fn := range expect.contextChecks() {
+		if err := fn(ctx); err != nil {
+			return fmt.Errorf("context not match, call to cmd '%+v': %w", cmd.Args(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package redismock
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// UnmetExpectation is an expectation that did not get all its calls.
+type UnmetExpectation struct {
+	// Name is the name given with Named.
+	Name string
+
+	// Cmd is the name of the expected command.
+	Cmd  string
+	Args []interface{}
+
+	// Matcher describes the Matcher of the expectation, if it has one.
+	Matcher string
+
+	// Times is the number of calls the expectation waits for, Calls the number it got.
+	Times int
+	Calls int
+
+	// Site is the file:line where the expectation was registered.
+	Site string
+}
+
+func (u UnmetExpectation) String() string {
+	s := fmt.Sprintf("%+v", u.Args)
+	if u.Name != "" {
+		s = u.Name + " " + s
+	}
+	if u.Matcher != "" {
+		s += fmt.Sprintf(" matching '%s'", u.Matcher)
+	}
+	return s + fmt.Sprintf(" (called %d of %d times, registered at %s)", u.Calls, u.Times, u.Site)
+}
+
+// UnmetExpectationsError is returned by ExpectationsWereMet, it lists every unmet expectation.
+type UnmetExpectationsError struct {
+	Expectations []UnmetExpectation
+}
+
+func (e *UnmetExpectationsError) Error() string {
+	if len(e.Expectations) == 1 {
+		return "there is a remaining expectation which was not matched: " + e.Expectations[0].String()
+	}
+	lines := make([]string, len(e.Expectations))
+	for i, u := range e.Expectations {
+		lines[i] = "\t" + u.String()
+	}
+	return fmt.Sprintf("there are %d remaining expectations which were not matched:\n%s",
+		len(e.Expectations), strings.Join(lines, "\n"))
+}
+
+// UnexpectedCallError is the error of a command that matched no expectation.
+type UnexpectedCallError struct {
+	Cmd redis.Cmder
+
+	// Err is why the command did not match the next expectation, when expectations are matched in order.
+	Err error
+
+	// Fulfilled reports whether all expectations were already met when the command was called.
+	Fulfilled bool
+}
+
+func (e *UnexpectedCallError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	msg := fmt.Sprintf("call to cmd '%+v' was not expected", e.Cmd.Args())
+	if e.Fulfilled {
+		msg = "all expectations were already fulfilled, " + msg
+	}
+	return msg
+}
+
+func (e *UnexpectedCallError) Unwrap() error {
+	return e.Err
+}
+
+// unmetExpectation describes expect if it did not get all its calls.
+func unmetExpectation(expect expectation) (UnmetExpectation, bool) {
+	expect.lock()
+	defer expect.unlock()
+
+	calls, times := expect.counts()
+	if calls >= times {
+		return UnmetExpectation{}, false
+	}
+	u := UnmetExpectation{
+		Name:  expect.named(),
+		Cmd:   expect.name(),
+		Args:  expect.args(),
+		Times: times,
+		Calls: calls,
+		Site:  expect.site(),
+	}
+	if mt := expect.matcher(); mt != nil {
+		u.Matcher = mt.String()
+	}
+	return u, true
+}
+
+// callerSite returns the file:line of the first caller outside of the package, the test
+// registering an expectation.
+func callerSite() string {
+	pc := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pc[:runtime.Callers(2, pc)])
+	for {
+		frame, more := frames.Next()
+		inPackage := strings.HasPrefix(frame.Function, "github.com/go-redis/redismock/v9.") &&
+			!strings.HasSuffix(frame.File, "_test.go")
+		if !inPackage {
+			return fmt.Sprintf("%s:%d", filepath.Base(frame.File), frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package redismock
 
 import (
+	"context"
 	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 	"unsafe"
@@ -14,16 +17,38 @@ type baseMock interface {
 	// ClearExpect clear whether all queued expectations were met in order
 	ClearExpect()
 
+	// With returns a scope registering its expectations with opts, on top of the options of
+	// the mock it is called on. All the methods of the scope act on the same mock.
+	//
+	//	mock.With(redismock.Regexp, redismock.Times(2), redismock.Named("refresh")).ExpectGet(`^user:\d+$`)
+	With(opts ...Option) *mock
+
 	// Regexp using the regular match command
 	Regexp() *mock
 
 	// CustomMatch using custom matching functions
 	CustomMatch(fn CustomMatch) *mock
 
+	// ForSlot only matches the following expectations with commands whose keys all hash to slot, see Slot.
+	ForSlot(slot int) *mock
+
+	// ForHashTag is ForSlot with the slot of tag, "{user:1}" and "user:1" are the same tag.
+	ForHashTag(tag string) *mock
+
+	// Calls returns the commands received by the mock with their context, in the order they were received.
+	Calls() []Call
+
 	// ExpectationsWereMet checks whether all queued expectations
 	// were met in order. If any of them was not met - an error is returned.
 	ExpectationsWereMet() error
 
+	// WaitForExpectations blocks until all queued expectations were met, or returns the
+	// error of ExpectationsWereMet when ctx is done.
+	WaitForExpectations(ctx context.Context) error
+
+	// WaitForExpectationsWithTimeout is WaitForExpectations with a context that times out after d.
+	WaitForExpectationsWithTimeout(d time.Duration) error
+
 	// MatchExpectationsInOrder gives an option whether to match all expectations in the order they were set or not.
 	MatchExpectationsInOrder(b bool)
 
@@ -300,6 +325,7 @@ type baseMock interface {
 	ExpectEvalSha(sha1 string, keys []string, args ...interface{}) *ExpectedCmd
 	ExpectEvalRO(script string, keys []string, args ...interface{}) *ExpectedCmd
 	ExpectEvalShaRO(sha1 string, keys []string, args ...interface{}) *ExpectedCmd
+	ExpectScript(script *redis.Script, keys []string, args ...interface{}) *ExpectedScript
 	ExpectScriptExists(hashes ...string) *ExpectedBoolSlice
 	ExpectScriptFlush() *ExpectedStatus
 	ExpectScriptKill() *ExpectedStatus
@@ -323,8 +349,13 @@ type baseMock interface {
 	ExpectClusterResetSoft() *ExpectedStatus
 	ExpectClusterResetHard() *ExpectedStatus
 	ExpectClusterInfo() *ExpectedString
+
+	// ExpectClusterKeySlot answers with the slot of the key if no value is set.
 	ExpectClusterKeySlot(key string) *ExpectedInt
+	// ExpectClusterGetKeysInSlot answers with the keys of the registered expectations
+	// hashing to slot if no value is set.
 	ExpectClusterGetKeysInSlot(slot int, count int) *ExpectedStringSlice
+
 	ExpectClusterCountFailureReports(nodeID string) *ExpectedInt
 	ExpectClusterCountKeysInSlot(slot int) *ExpectedInt
 	ExpectClusterDelSlots(slots ...int) *ExpectedStatus
@@ -355,27 +386,142 @@ type baseMock interface {
 	ExpectFunctionList(q redis.FunctionListQuery) *ExpectedFunctionList
 	ExpectFunctionDump() *ExpectedString
 	ExpectFunctionRestore(libDump string) *ExpectedString
+	ExpectFunctionKill() *ExpectedStatus
+	ExpectFunctionStats() *ExpectedFunctionStats
+	ExpectFCall(function string, keys []string, args ...interface{}) *ExpectedFCall
+	ExpectFCallRO(function string, keys []string, args ...interface{}) *ExpectedFCall
+
+	// PreloadFunctions registers a library as already loaded on the server,
+	// FCALL expectations of its functions succeed without an expected FUNCTION LOAD.
+	PreloadFunctions(code string) error
 
 	ExpectACLDryRun(username string, command ...interface{}) *ExpectedString
 }
 
+// PipelineExpectations collects the commands expected within a single pipeline Exec.
+type PipelineExpectations interface {
+	baseMock
+	statefulMock
+}
+
+// statefulMock expects the commands of redis.StatefulCmdable, sent by a redis.Conn or a pipeline.
+type statefulMock interface {
+	ExpectAuth(password string) *ExpectedStatus
+	ExpectAuthACL(username, password string) *ExpectedStatus
+	ExpectSelect(index int) *ExpectedStatus
+	ExpectSwapDB(index1, index2 int) *ExpectedStatus
+	ExpectClientSetName(name string) *ExpectedBool
+	ExpectHello(ver int, username, password, clientName string) *ExpectedMapStringInterface
+}
+
+// ConnMock holds the expectations of a single connection returned by client.Conn().
+type ConnMock interface {
+	baseMock
+	pipelineMock
+	statefulMock
+}
+
+type connMock interface {
+	// ExpectConn scopes expectations to the next connection dialed by client.Conn(), a Conn
+	// dials when it sends its first command. The commands sent on that connection are only
+	// matched against the returned ConnMock.
+	// Connections dialed once all scopes are claimed use the expectations of the client mock.
+	ExpectConn() ConnMock
+
+	// ConnHook passes the context of the commands sent on a Conn to the expectations, add it
+	// with conn.AddHook before the first command of the Conn. Without it the commands of a Conn
+	// are matched and recorded with context.Background, go-redis does not run the hooks of
+	// the client for them.
+	ConnHook() redis.Hook
+}
+
 type pipelineMock interface {
+	// ExpectPipeline expects all commands registered in fn to be sent by one Pipeline Exec.
+	ExpectPipeline(fn func(p PipelineExpectations)) *ExpectedPipeline
 	ExpectTxPipeline()
-	ExpectTxPipelineExec() *ExpectedSlice
+	ExpectTxPipelineExec() *ExpectedTxExec
 }
 
 type watchMock interface {
 	ExpectWatch(keys ...string) *ExpectedError
+	ExpectUnwatch() *ExpectedStatus
+
+	// ModifyWatchedKeys simulates another client modifying keys between WATCH and EXEC,
+	// the next EXEC fails with redis.TxFailedErr if any of the keys is watched.
+	ModifyWatchedKeys(keys ...string)
+}
+
+type scriptMock interface {
+	// ExecuteScripts runs EVAL, EVALSHA, FCALL and their _RO variants in an embedded Lua VM
+	// instead of matching them against expectations, redis.call and redis.pcall operate on ks.
+	// SCRIPT LOAD/EXISTS/FLUSH and FUNCTION LOAD/DELETE/FLUSH are executed as well.
+	ExecuteScripts(ks *Keyspace)
 }
 
 type ClientMock interface {
 	baseMock
 	pipelineMock
 	watchMock
+	scriptMock
+	statefulMock
+	connMock
+}
+
+type clusterMock interface {
+	// Node scopes expectations to the node addr of a simulated cluster, see NewClusterMockWithTopology.
+	// They only match commands the ClusterClient sent to that node, and fail if their key
+	// hashes to a slot the node does not serve.
+	Node(addr string) *mock
+
+	// MigrateSlot moves slot to the shard of the master addr, like a finished resharding.
+	// Its previous nodes answer the commands of the slot with MOVED, CLUSTER SLOTS reports
+	// the new owner. addr may be a node that is not part of the cluster yet.
+	MigrateSlot(slot int, addr string)
+
+	// StartSlotMigration starts migrating slot to addr, the master of the slot answers its
+	// commands with ASK, and addr only accepts them after ASKING. Finish it with MigrateSlot.
+	StartSlotMigration(slot int, addr string)
+
+	// Master scopes expectations to the masters of a simulated cluster.
+	Master() *mock
+
+	// Replica scopes expectations to the replicas of a simulated cluster, a ClusterClient
+	// sends read-only commands to them with ReadOnly, RouteByLatency or RouteRandomly.
+	// A replica lagging behind its master is simulated with the values its expectations
+	// reply, a failing replica with their errors (LOADING, or a network error), the client
+	// then retries on another node.
+	Replica() *mock
+
+	// ReplicaWrites returns the number of write commands sent to a replica. Like a real
+	// replica, it answers them with READONLY, the ClusterClient then retries on the master.
+	ReplicaWrites() int
+
+	// NodeDown fails every command sent to the node addr with failure, CLUSTER SLOTS included,
+	// until NodeUp. The ClusterClient retries on the node, then on another node if it can.
+	NodeDown(addr string, failure NodeFailure)
+	NodeUp(addr string)
+
+	// Failover promotes the replica addr to master of the slots it replicates, their master
+	// becomes a replica. The ClusterClient sees it when it reloads CLUSTER SLOTS.
+	Failover(addr string)
+
+	// Served returns the number of commands served by the node addr. Commands the node
+	// answered by itself (CLUSTER SLOTS, COMMAND, redirects) or failed while down are not counted.
+	Served(addr string) int
+
+	// Redirects returns the number of MOVED and ASK replies sent by the nodes.
+	Redirects() (moved, ask int)
+
+	// CheckCrossSlot rejects commands and scripts whose keys hash to different slots with the
+	// CROSSSLOT error of redis-server, before they are matched against the expectations.
+	// The keys are found with the key positions reported by COMMAND.
+	CheckCrossSlot(b bool)
 }
 
 type ClusterClientMock interface {
 	baseMock
+	scriptMock
+	clusterMock
 }
 
 func inflow(cmd redis.Cmder, key string, val interface{}) {
@@ -392,6 +538,14 @@ func inflow(cmd redis.Cmder, key string, val interface{}) {
 	v.Set(setVal)
 }
 
+// blockingExpectation is implemented by expectations of blocking commands,
+// the caller is parked until the test delivers the reply, see mock.park.
+type blockingExpectation interface {
+	blocking() bool
+	arrival(cmd redis.Cmder) (ready <-chan struct{}, timeout time.Duration)
+	arrived()
+}
+
 type expectation interface {
 	regexp() bool
 	setRegexpMatch()
@@ -399,13 +553,31 @@ type expectation interface {
 	setCustomMatch(fn CustomMatch)
 	usable() bool
 	trigger()
+	Done() <-chan struct{}
 
 	name() string
 	args() []interface{}
+	command() redis.Cmder
+
+	placement() placement
+	setPlacement(p placement)
+	contextChecks() []func(ctx context.Context) error
+	gated() *Gate
+	counts() (calls, times int)
+	setTimes(n int)
+	named() string
+	setName(name string)
+	matcher() Matcher
+	setMatcher(mt Matcher)
+	site() string
+	setSite(site string)
 
 	error() error
 	SetErr(err error)
 
+	queueError() error
+	SetQueueErr(err error)
+
 	RedisNil()
 	isRedisNil() bool
 
@@ -422,12 +594,30 @@ type CustomMatch func(expected, actual []interface{}) error
 type expectedBase struct {
 	cmd         redis.Cmder
 	err         error
+	queueErr    error
 	redisNil    bool
-	triggered   bool
 	setVal      bool
 	regexpMatch bool
 	customMatch CustomMatch
 
+	place     placement
+	ctxChecks []func(ctx context.Context) error
+
+	gate *Gate
+
+	// registeredAt is the file:line of the test that registered the expectation
+	registeredAt string
+	label        string
+	match        Matcher
+
+	// the expectation is met after times calls, 0 is once
+	times, calls int
+
+	// done is closed when the expectation fires, see Done
+	doneMu sync.Mutex
+	done   chan struct{}
+	fired  bool
+
 	rw sync.RWMutex
 }
 
@@ -456,11 +646,48 @@ func (base *expectedBase) setCustomMatch(fn CustomMatch) {
 }
 
 func (base *expectedBase) usable() bool {
-	return !base.triggered
+	return base.calls < base.expectedTimes()
 }
 
+func (base *expectedBase) expectedTimes() int {
+	if base.times > 0 {
+		return base.times
+	}
+	return 1
+}
+
+func (base *expectedBase) setTimes(n int) {
+	base.times = n
+}
+
+// trigger counts a call matching the expectation.
 func (base *expectedBase) trigger() {
-	base.triggered = true
+	base.calls++
+	if base.usable() {
+		return
+	}
+
+	base.doneMu.Lock()
+	defer base.doneMu.Unlock()
+	if !base.fired {
+		base.fired = true
+		if base.done != nil {
+			close(base.done)
+		}
+	}
+}
+
+// Done returns a channel closed when the expectation got all its calls.
+func (base *expectedBase) Done() <-chan struct{} {
+	base.doneMu.Lock()
+	defer base.doneMu.Unlock()
+	if base.done == nil {
+		base.done = make(chan struct{})
+		if base.fired {
+			close(base.done)
+		}
+	}
+	return base.done
 }
 
 func (base *expectedBase) name() string {
@@ -471,6 +698,79 @@ func (base *expectedBase) args() []interface{} {
 	return base.cmd.Args()
 }
 
+func (base *expectedBase) command() redis.Cmder {
+	return base.cmd
+}
+
+func (base *expectedBase) placement() placement {
+	return base.place
+}
+
+func (base *expectedBase) setPlacement(p placement) {
+	base.place = p
+}
+
+func (base *expectedBase) contextChecks() []func(ctx context.Context) error {
+	return base.ctxChecks
+}
+
+// WithContext only matches the expectation with commands issued with a context fn accepts,
+// see HasDeadlineWithin and HasValue.
+func (base *expectedBase) WithContext(fn func(ctx context.Context) error) {
+	base.ctxChecks = append(base.ctxChecks, fn)
+}
+
+func (base *expectedBase) counts() (calls, times int) {
+	return base.calls, base.expectedTimes()
+}
+
+func (base *expectedBase) named() string {
+	return base.label
+}
+
+func (base *expectedBase) setName(name string) {
+	base.label = name
+}
+
+func (base *expectedBase) site() string {
+	return base.registeredAt
+}
+
+func (base *expectedBase) setSite(site string) {
+	base.registeredAt = site
+}
+
+func (base *expectedBase) matcher() Matcher {
+	return base.match
+}
+
+func (base *expectedBase) setMatcher(mt Matcher) {
+	base.match = mt
+}
+
+// WithMatcher matches the commands of the expectation with mt, in place of its name and arguments.
+func (base *expectedBase) WithMatcher(mt Matcher) {
+	base.match = mt
+}
+
+func (base *expectedBase) gated() *Gate {
+	return base.gate
+}
+
+// Gate holds the goroutine of the command matching the expectation inside the mock, before
+// it gets its reply, until the returned handle is released or the context of the command is done.
+func (base *expectedBase) Gate() *Gate {
+	base.gate = &Gate{arrived: make(chan struct{}), open: make(chan struct{})}
+	return base.gate
+}
+
+// BlockUntil is Gate, the command is also released when ch is closed.
+func (base *expectedBase) BlockUntil(ch <-chan struct{}) *Gate {
+	gate := base.Gate()
+	gate.until = ch
+	return gate
+}
+
 func (base *expectedBase) SetErr(err error) {
 	base.err = err
 }
@@ -479,6 +779,16 @@ func (base *expectedBase) error() error {
 	return base.err
 }
 
+// SetQueueErr sets the error returned when the command is queued after MULTI,
+// e.g. a syntax error. It makes EXEC fail with EXECABORT.
+func (base *expectedBase) SetQueueErr(err error) {
+	base.queueErr = err
+}
+
+func (base *expectedBase) queueError() error {
+	return base.queueErr
+}
+
 func (base *expectedBase) RedisNil() {
 	base.redisNil = true
 }
@@ -515,6 +825,7 @@ func (cmd *ExpectedCommandsInfo) inflow(c redis.Cmder) {
 
 type ExpectedString struct {
 	expectedBase
+	blockingPop
 
 	val string
 }
@@ -583,6 +894,7 @@ func (cmd *ExpectedBool) inflow(c redis.Cmder) {
 
 type ExpectedStringSlice struct {
 	expectedBase
+	blockingPop
 
 	val []string
 }
@@ -653,6 +965,18 @@ func (cmd *ExpectedSlice) inflow(c redis.Cmder) {
 
 // ------------------------------------------------------------
 
+type ExpectedTxExec struct {
+	ExpectedSlice
+}
+
+// Abort makes EXEC return a null reply, as if a watched key was modified,
+// so that go-redis returns redis.TxFailedErr.
+func (cmd *ExpectedTxExec) Abort() {
+	cmd.SetErr(redis.TxFailedErr)
+}
+
+// ------------------------------------------------------------
+
 type ExpectedFloat struct {
 	expectedBase
 
@@ -747,6 +1071,26 @@ func (cmd *ExpectedMapStringString) inflow(c redis.Cmder) {
 
 // ------------------------------------------------------------
 
+type ExpectedMapStringInterface struct {
+	expectedBase
+
+	val map[string]interface{}
+}
+
+func (cmd *ExpectedMapStringInterface) SetVal(val map[string]interface{}) {
+	cmd.setVal = true
+	cmd.val = make(map[string]interface{})
+	for k, v := range val {
+		cmd.val[k] = v
+	}
+}
+
+func (cmd *ExpectedMapStringInterface) inflow(c redis.Cmder) {
+	inflow(c, "val", cmd.val)
+}
+
+// ------------------------------------------------------------
+
 type ExpectedStringStructMap struct {
 	expectedBase
 
@@ -789,6 +1133,27 @@ type ExpectedXStreamSlice struct {
 	expectedBase
 
 	val []redis.XStream
+
+	arrive *XStreamArrival
+}
+
+// Block parks XREAD/XREADGROUP until entries are pushed through the returned handle,
+// the BLOCK timeout expires (redis.Nil) or the context of the command is done.
+func (cmd *ExpectedXStreamSlice) Block() *XStreamArrival {
+	cmd.arrive = &XStreamArrival{ready: make(chan struct{})}
+	return cmd.arrive
+}
+
+func (cmd *ExpectedXStreamSlice) blocking() bool {
+	return cmd.arrive != nil
+}
+
+func (cmd *ExpectedXStreamSlice) arrival(c redis.Cmder) (<-chan struct{}, time.Duration) {
+	return cmd.arrive.ready, blockTimeout(c.Args())
+}
+
+func (cmd *ExpectedXStreamSlice) arrived() {
+	cmd.SetVal(cmd.arrive.take())
 }
 
 func (cmd *ExpectedXStreamSlice) SetVal(val []redis.XStream) {
@@ -801,6 +1166,131 @@ func (cmd *ExpectedXStreamSlice) inflow(c redis.Cmder) {
 	inflow(c, "val", cmd.val)
 }
 
+// XStreamArrival delivers the entries a blocking XREAD/XREADGROUP is waiting for.
+type XStreamArrival struct {
+	mu      sync.Mutex
+	streams []redis.XStream
+	ready   chan struct{}
+	pushed  bool
+}
+
+// Push wakes up the parked command, which returns the streams.
+// Streams pushed before the command is sent are returned without waiting.
+func (a *XStreamArrival) Push(streams ...redis.XStream) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.streams = append(a.streams, streams...)
+	if !a.pushed {
+		a.pushed = true
+		close(a.ready)
+	}
+}
+
+func (a *XStreamArrival) take() []redis.XStream {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.streams
+}
+
+// ------------------------------------------------------------
+
+// BlockedPop holds the reply of a blocking pop until the test releases it.
+type BlockedPop struct {
+	once  sync.Once
+	ready chan struct{}
+}
+
+// Release wakes up the parked command, which returns the value set on its expectation.
+// Releasing before the command is sent makes it return without waiting.
+func (p *BlockedPop) Release() {
+	p.once.Do(func() {
+		close(p.ready)
+	})
+}
+
+// Gate holds a command inside the mock until the test releases it, see Gate and BlockUntil.
+type Gate struct {
+	arriveOnce, openOnce sync.Once
+
+	arrived chan struct{}
+	open    chan struct{}
+	until   <-chan struct{}
+}
+
+// Arrived returns a channel closed when the command reached the gate.
+func (g *Gate) Arrived() <-chan struct{} {
+	return g.arrived
+}
+
+// Release lets the held command get its reply.
+// Releasing before the command is sent makes it pass without waiting.
+func (g *Gate) Release() {
+	g.openOnce.Do(func() {
+		close(g.open)
+	})
+}
+
+func (g *Gate) arrive() {
+	g.arriveOnce.Do(func() {
+		close(g.arrived)
+	})
+}
+
+// blockingPop makes the expectation of BLPOP, BRPOP, BLMOVE, BZPOPMIN... optionally park the caller,
+// it is embedded in the expectations sharing the reply type of these commands.
+type blockingPop struct {
+	pop *BlockedPop
+
+	// timeoutArg is the position of the timeout in the args of the command, counted from
+	// the end if negative. It is 0 for the other commands of the reply type, they can not block.
+	timeoutArg int
+}
+
+// Block parks the command until the returned handle is released, the timeout sent with the
+// command expires (redis.Nil) or the context of the command is done. Like go-redis, which extends
+// the read deadline of blocking commands, Options.ReadTimeout does not cut the wait short.
+// Block panics if the expectation is not the one of a blocking pop.
+func (b *blockingPop) Block() *BlockedPop {
+	if b.timeoutArg == 0 {
+		panic("redismock: Block is only supported by the expectations of blocking pops")
+	}
+	b.pop = &BlockedPop{ready: make(chan struct{})}
+	return b.pop
+}
+
+func (b *blockingPop) blocking() bool {
+	return b.pop != nil
+}
+
+func (b *blockingPop) arrival(cmd redis.Cmder) (<-chan struct{}, time.Duration) {
+	return b.pop.ready, popTimeout(cmd.Args(), b.timeoutArg)
+}
+
+func (b *blockingPop) arrived() {}
+
+// popTimeout reads the timeout seconds of a blocking pop at position i of args, 0 blocks forever.
+func popTimeout(args []interface{}, i int) time.Duration {
+	if i < 0 {
+		i += len(args)
+	}
+	if i <= 0 || i >= len(args) {
+		return 0
+	}
+	sec, _ := strconv.ParseFloat(fmt.Sprint(args[i]), 64)
+	return time.Duration(sec * float64(time.Second))
+}
+
+// blockTimeout reads the BLOCK milliseconds of XREAD/XREADGROUP, 0 blocks forever.
+func blockTimeout(args []interface{}) time.Duration {
+	for i := 0; i+1 < len(args); i++ {
+		if s, ok := args[i].(string); ok && strings.EqualFold(s, "block") {
+			ms, _ := strconv.ParseInt(fmt.Sprint(args[i+1]), 10, 64)
+			return time.Duration(ms) * time.Millisecond
+		}
+	}
+	return 0
+}
+
 // ------------------------------------------------------------
 
 type ExpectedXPending struct {
@@ -955,6 +1445,7 @@ func (cmd *ExpectedXInfoStreamFull) inflow(c redis.Cmder) {
 
 type ExpectedZWithKey struct {
 	expectedBase
+	blockingPop
 
 	val *redis.ZWithKey
 }
@@ -1023,6 +1514,57 @@ func (cmd *ExpectedCmd) inflow(c redis.Cmder) {
 
 // ------------------------------------------------------------
 
+// ExpectedScript matches redis.Script.Run, which sends EVALSHA and falls back to EVAL.
+type ExpectedScript struct {
+	ExpectedCmd
+
+	hash    string
+	evalCmd redis.Cmder
+
+	noScript    bool
+	missed      bool
+	noScriptNow bool
+}
+
+// NoScript simulates a cold script cache, EVALSHA gets a NOSCRIPT error and EVAL must follow.
+func (cmd *ExpectedScript) NoScript() *ExpectedScript {
+	cmd.noScript = true
+	return cmd
+}
+
+func (cmd *ExpectedScript) trigger() {
+	if cmd.noScriptNow {
+		cmd.missed = true
+		return
+	}
+	cmd.ExpectedCmd.trigger()
+}
+
+func (cmd *ExpectedScript) error() error {
+	if cmd.noScriptNow {
+		return errNoScript
+	}
+	return cmd.ExpectedCmd.error()
+}
+
+// ExpectedFCall fails with "Function not found" unless the function was loaded,
+// either by a matched FUNCTION LOAD or by PreloadFunctions.
+type ExpectedFCall struct {
+	ExpectedCmd
+
+	function  string
+	functions *functionState
+}
+
+func (cmd *ExpectedFCall) error() error {
+	if !cmd.functions.has(cmd.function) {
+		return errNoFunction
+	}
+	return cmd.ExpectedCmd.error()
+}
+
+// ------------------------------------------------------------
+
 type ExpectedBoolSlice struct {
 	expectedBase
 
@@ -1153,6 +1695,7 @@ func (cmd *ExpectedGeoSearchLocation) inflow(c redis.Cmder) {
 
 type ExpectedKeyValues struct {
 	expectedBase
+	blockingPop
 
 	key string
 	val []string
@@ -1174,6 +1717,7 @@ func (cmd *ExpectedKeyValues) inflow(c redis.Cmder) {
 
 type ExpectedZSliceWithKey struct {
 	expectedBase
+	blockingPop
 
 	key string
 	val []redis.Z
@@ -1229,6 +1773,74 @@ func (cmd *ExpectedFunctionList) inflow(c redis.Cmder) {
 
 // ------------------------------------------------------------
 
+// FunctionStats is the reply of FUNCTION STATS. go-redis sends the command with Do,
+// the *redis.Cmd gets the reply in the layout of RESP2.
+type FunctionStats struct {
+	// RunningScript is the function being executed, nil if there is none.
+	RunningScript *RunningFunction
+	Engines       []FunctionEngine
+}
+
+// RunningFunction is the function a FUNCTION STATS reply reports as running.
+type RunningFunction struct {
+	Name     string
+	Command  []string
+	Duration time.Duration
+}
+
+// FunctionEngine counts the libraries and functions of an engine, such as LUA.
+type FunctionEngine struct {
+	Language       string
+	LibrariesCount int64
+	FunctionsCount int64
+}
+
+func (s FunctionStats) reply() []interface{} {
+	var running interface{}
+	if rs := s.RunningScript; rs != nil {
+		command := make([]interface{}, len(rs.Command))
+		for i, arg := range rs.Command {
+			command[i] = arg
+		}
+		running = []interface{}{
+			"name", rs.Name,
+			"command", command,
+			"duration_ms", int64(rs.Duration / time.Millisecond),
+		}
+	}
+	engines := make([]interface{}, 0, 2*len(s.Engines))
+	for _, e := range s.Engines {
+		engines = append(engines, e.Language, []interface{}{
+			"libraries_count", e.LibrariesCount,
+			"functions_count", e.FunctionsCount,
+		})
+	}
+	return []interface{}{"running_script", running, "engines", engines}
+}
+
+type ExpectedFunctionStats struct {
+	expectedBase
+
+	val FunctionStats
+}
+
+func (cmd *ExpectedFunctionStats) SetVal(val FunctionStats) {
+	cmd.setVal = true
+	cmd.val = val
+	if val.RunningScript != nil {
+		rs := *val.RunningScript
+		rs.Command = append([]string(nil), rs.Command...)
+		cmd.val.RunningScript = &rs
+	}
+	cmd.val.Engines = append([]FunctionEngine(nil), val.Engines...)
+}
+
+func (cmd *ExpectedFunctionStats) inflow(c redis.Cmder) {
+	inflow(c, "val", cmd.val.reply())
+}
+
+// ------------------------------------------------------------
+
 type ExpectedLCS struct {
 	expectedBase
 
@@ -1287,4 +1899,40 @@ type ExpectedError struct {
 	expectedBase
 }
 
-func (cmd *ExpectedError) inflow(c redis.Cmder) {}
+// inflow replies OK to a WATCH sent on a connection, WATCH replies nothing else.
+func (cmd *ExpectedError) inflow(c redis.Cmder) {
+	if _, ok := c.(*wireCmd); ok {
+		inflow(c, "val", "OK")
+	}
+}
+
+// ------------------------------------------------------------
+
+type ExpectedPipeline struct {
+	expectedBase
+
+	group     *mock
+	unordered bool
+
+	matched []expectation
+}
+
+// Unordered allows the commands of the pipeline to be sent in any order.
+func (cmd *ExpectedPipeline) Unordered() *ExpectedPipeline {
+	cmd.unordered = true
+	return cmd
+}
+
+func (cmd *ExpectedPipeline) name() string {
+	return "pipeline"
+}
+
+func (cmd *ExpectedPipeline) args() []interface{} {
+	args := make([]interface{}, len(cmd.group.expected))
+	for i, e := range cmd.group.expected {
+		args[i] = e.args()
+	}
+	return args
+}
+
+func (cmd *ExpectedPipeline) inflow(c redis.Cmder) {}
@@ -6,6 +6,7 @@ require (
 	github.com/onsi/ginkgo v1.16.5
 	github.com/onsi/gomega v1.25.0
 	github.com/redis/go-redis/v9 v9.0.3-0.20230329134406-9aba95a74fa2
+	github.com/yuin/gopher-lua v1.1.1
 )
 
 require (
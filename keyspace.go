@@ -0,0 +1,1413 @@
+package redismock
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Keyspace is an in-memory dataset, scripts executed by the mock read and write it
+// through redis.call/redis.pcall. See ExecuteScripts.
+type Keyspace struct {
+	mu   sync.Mutex
+	data map[string]*keyspaceEntry
+}
+
+type keyspaceEntry struct {
+	// string, map[string]string (hash), []string (list),
+	// map[string]struct{} (set) or map[string]float64 (zset)
+	value    interface{}
+	expireAt time.Time
+}
+
+// statusReply is a simple string reply, e.g. +OK.
+type statusReply string
+
+type keyspaceCommand struct {
+	// same as redis-server: N means exactly N args, -N means at least N args (including the name)
+	arity int
+	write bool
+	fn    func(ks *Keyspace, args []string) (interface{}, error)
+}
+
+var (
+	errWrongType    = newRedisError("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errNotInteger   = newRedisError("ERR value is not an integer or out of range")
+	errNotFloat     = newRedisError("ERR value is not a valid float")
+	errSyntax       = newRedisError("ERR syntax error")
+	errMinMaxFloat  = newRedisError("ERR min or max is not a float")
+	errInvalidTTL   = newRedisError("ERR invalid expire time in 'set' command")
+	errHashNotFloat = newRedisError("ERR hash value is not a float")
+)
+
+var keyspaceCommands map[string]keyspaceCommand
+
+func init() {
+	keyspaceCommands = map[string]keyspaceCommand{
+		"ping":   {-1, false, cmdPing},
+		"time":   {1, false, cmdTime},
+		"del":    {-2, true, cmdDel},
+		"unlink": {-2, true, cmdDel},
+		"exists": {-2, false, cmdExists},
+		"type":   {2, false, cmdType},
+
+		"expire":    {-3, true, cmdExpire(time.Second, false)},
+		"pexpire":   {-3, true, cmdExpire(time.Millisecond, false)},
+		"expireat":  {-3, true, cmdExpire(time.Second, true)},
+		"pexpireat": {-3, true, cmdExpire(time.Millisecond, true)},
+		"ttl":       {2, false, cmdTTL(time.Second)},
+		"pttl":      {2, false, cmdTTL(time.Millisecond)},
+		"persist":   {2, true, cmdPersist},
+
+		"get":         {2, false, cmdGet},
+		"set":         {-3, true, cmdSet},
+		"setnx":       {3, true, cmdSetNX},
+		"setex":       {4, true, cmdSetEX(time.Second)},
+		"psetex":      {4, true, cmdSetEX(time.Millisecond)},
+		"getset":      {3, true, cmdGetSet},
+		"getdel":      {2, true, cmdGetDel},
+		"mget":        {-2, false, cmdMGet},
+		"mset":        {-3, true, cmdMSet},
+		"incr":        {2, true, cmdIncrBy(1, false)},
+		"decr":        {2, true, cmdIncrBy(-1, false)},
+		"incrby":      {3, true, cmdIncrBy(1, true)},
+		"decrby":      {3, true, cmdIncrBy(-1, true)},
+		"incrbyfloat": {3, true, cmdIncrByFloat},
+		"append":      {3, true, cmdAppend},
+		"strlen":      {2, false, cmdStrLen},
+
+		"hget":         {3, false, cmdHGet},
+		"hset":         {-4, true, cmdHSet},
+		"hmset":        {-4, true, cmdHMSet},
+		"hsetnx":       {4, true, cmdHSetNX},
+		"hdel":         {-3, true, cmdHDel},
+		"hexists":      {3, false, cmdHExists},
+		"hlen":         {2, false, cmdHLen},
+		"hmget":        {-3, false, cmdHMGet},
+		"hgetall":      {2, false, cmdHGetAll},
+		"hkeys":        {2, false, cmdHKeys},
+		"hvals":        {2, false, cmdHVals},
+		"hincrby":      {4, true, cmdHIncrBy},
+		"hincrbyfloat": {4, true, cmdHIncrByFloat},
+
+		"lpush":  {-3, true, cmdPush(true)},
+		"rpush":  {-3, true, cmdPush(false)},
+		"lpop":   {-2, true, cmdPop(true)},
+		"rpop":   {-2, true, cmdPop(false)},
+		"llen":   {2, false, cmdLLen},
+		"lrange": {4, false, cmdLRange},
+		"lindex": {3, false, cmdLIndex},
+		"lrem":   {4, true, cmdLRem},
+		"ltrim":  {4, true, cmdLTrim},
+
+		"sadd":      {-3, true, cmdSAdd},
+		"srem":      {-3, true, cmdSRem},
+		"sismember": {3, false, cmdSIsMember},
+		"smembers":  {2, false, cmdSMembers},
+		"scard":     {2, false, cmdSCard},
+
+		"zadd":             {-4, true, cmdZAdd},
+		"zincrby":          {4, true, cmdZIncrBy},
+		"zrem":             {-3, true, cmdZRem},
+		"zscore":           {3, false, cmdZScore},
+		"zcard":            {2, false, cmdZCard},
+		"zcount":           {4, false, cmdZCount},
+		"zrange":           {-4, false, cmdZRange},
+		"zrangebyscore":    {-4, false, cmdZRangeByScore},
+		"zremrangebyscore": {4, true, cmdZRemRangeByScore},
+	}
+}
+
+func NewKeyspace() *Keyspace {
+	return &Keyspace{
+		data: make(map[string]*keyspaceEntry),
+	}
+}
+
+// Do executes a command against the keyspace, e.g. to seed data before a script runs
+// or to check what it wrote. Replies use the same Go types as redis.Cmd.
+func (ks *Keyspace) Do(args ...interface{}) (interface{}, error) {
+	ss, err := wireArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	val, err := ks.do(ss)
+	if err != nil {
+		return nil, err
+	}
+	return goReply(val), nil
+}
+
+// do executes a command, the caller must hold ks.mu.
+func (ks *Keyspace) do(args []string) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, newRedisError("ERR empty command")
+	}
+
+	name := strings.ToLower(args[0])
+	c, ok := keyspaceCommands[name]
+	if !ok {
+		return nil, newRedisError("ERR unknown command '" + args[0] + "'")
+	}
+	if (c.arity > 0 && len(args) != c.arity) || (c.arity < 0 && len(args) < -c.arity) {
+		return nil, newRedisError("ERR wrong number of arguments for '" + name + "' command")
+	}
+	return c.fn(ks, args[1:])
+}
+
+// isWriteCommand reports whether the command modifies the keyspace.
+func isWriteCommand(name string) bool {
+	return keyspaceCommands[strings.ToLower(name)].write
+}
+
+// goReply converts a keyspace reply to the values returned by go-redis.
+func goReply(val interface{}) interface{} {
+	switch v := val.(type) {
+	case statusReply:
+		return string(v)
+	case []interface{}:
+		vals := make([]interface{}, len(v))
+		for i := range v {
+			vals[i] = goReply(v[i])
+		}
+		return vals
+	default:
+		return v
+	}
+}
+
+func (ks *Keyspace) lookup(key string) *keyspaceEntry {
+	e, ok := ks.data[key]
+	if !ok {
+		return nil
+	}
+	if !e.expireAt.IsZero() && !time.Now().Before(e.expireAt) {
+		delete(ks.data, key)
+		return nil
+	}
+	return e
+}
+
+func (ks *Keyspace) getString(key string) (string, bool, error) {
+	e := ks.lookup(key)
+	if e == nil {
+		return "", false, nil
+	}
+	s, ok := e.value.(string)
+	if !ok {
+		return "", false, errWrongType
+	}
+	return s, true, nil
+}
+
+func (ks *Keyspace) getHash(key string, create bool) (map[string]string, error) {
+	e := ks.lookup(key)
+	if e == nil {
+		if !create {
+			return nil, nil
+		}
+		h := make(map[string]string)
+		ks.data[key] = &keyspaceEntry{value: h}
+		return h, nil
+	}
+	h, ok := e.value.(map[string]string)
+	if !ok {
+		return nil, errWrongType
+	}
+	return h, nil
+}
+
+func (ks *Keyspace) getList(key string) (*keyspaceEntry, []string, error) {
+	e := ks.lookup(key)
+	if e == nil {
+		return nil, nil, nil
+	}
+	l, ok := e.value.([]string)
+	if !ok {
+		return nil, nil, errWrongType
+	}
+	return e, l, nil
+}
+
+func (ks *Keyspace) getSet(key string, create bool) (map[string]struct{}, error) {
+	e := ks.lookup(key)
+	if e == nil {
+		if !create {
+			return nil, nil
+		}
+		s := make(map[string]struct{})
+		ks.data[key] = &keyspaceEntry{value: s}
+		return s, nil
+	}
+	s, ok := e.value.(map[string]struct{})
+	if !ok {
+		return nil, errWrongType
+	}
+	return s, nil
+}
+
+func (ks *Keyspace) getZSet(key string, create bool) (map[string]float64, error) {
+	e := ks.lookup(key)
+	if e == nil {
+		if !create {
+			return nil, nil
+		}
+		z := make(map[string]float64)
+		ks.data[key] = &keyspaceEntry{value: z}
+		return z, nil
+	}
+	z, ok := e.value.(map[string]float64)
+	if !ok {
+		return nil, errWrongType
+	}
+	return z, nil
+}
+
+// removeIfEmpty deletes keys holding an empty collection, like redis-server does.
+func (ks *Keyspace) removeIfEmpty(key string) {
+	e, ok := ks.data[key]
+	if !ok {
+		return
+	}
+	var n int
+	switch v := e.value.(type) {
+	case map[string]string:
+		n = len(v)
+	case []string:
+		n = len(v)
+	case map[string]struct{}:
+		n = len(v)
+	case map[string]float64:
+		n = len(v)
+	default:
+		return
+	}
+	if n == 0 {
+		delete(ks.data, key)
+	}
+}
+
+func parseInt(s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, errNotInteger
+	}
+	return n, nil
+}
+
+func parseFloat(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, errNotFloat
+	}
+	return f, nil
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// ------------------------------------------------------------
+
+func cmdPing(_ *Keyspace, args []string) (interface{}, error) {
+	if len(args) > 0 {
+		return args[0], nil
+	}
+	return statusReply("PONG"), nil
+}
+
+func cmdTime(_ *Keyspace, _ []string) (interface{}, error) {
+	now := time.Now()
+	return []interface{}{
+		strconv.FormatInt(now.Unix(), 10),
+		strconv.FormatInt(int64(now.Nanosecond()/1000), 10),
+	}, nil
+}
+
+func cmdDel(ks *Keyspace, args []string) (interface{}, error) {
+	var n int64
+	for _, key := range args {
+		if ks.lookup(key) != nil {
+			delete(ks.data, key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func cmdExists(ks *Keyspace, args []string) (interface{}, error) {
+	var n int64
+	for _, key := range args {
+		if ks.lookup(key) != nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func cmdType(ks *Keyspace, args []string) (interface{}, error) {
+	e := ks.lookup(args[0])
+	if e == nil {
+		return statusReply("none"), nil
+	}
+	switch e.value.(type) {
+	case string:
+		return statusReply("string"), nil
+	case map[string]string:
+		return statusReply("hash"), nil
+	case []string:
+		return statusReply("list"), nil
+	case map[string]struct{}:
+		return statusReply("set"), nil
+	default:
+		return statusReply("zset"), nil
+	}
+}
+
+func cmdExpire(unit time.Duration, at bool) func(ks *Keyspace, args []string) (interface{}, error) {
+	return func(ks *Keyspace, args []string) (interface{}, error) {
+		n, err := parseInt(args[1])
+		if err != nil {
+			return nil, err
+		}
+		e := ks.lookup(args[0])
+		if e == nil {
+			return int64(0), nil
+		}
+
+		var expireAt time.Time
+		if at {
+			// time.Duration overflows past 2262
+			expireAt = time.Unix(n/int64(time.Second/unit), n%int64(time.Second/unit)*int64(unit))
+		} else {
+			expireAt = time.Now().Add(time.Duration(n) * unit)
+		}
+
+		for _, opt := range args[2:] {
+			switch strings.ToLower(opt) {
+			case "nx":
+				if !e.expireAt.IsZero() {
+					return int64(0), nil
+				}
+			case "xx":
+				if e.expireAt.IsZero() {
+					return int64(0), nil
+				}
+			case "gt":
+				if e.expireAt.IsZero() || !expireAt.After(e.expireAt) {
+					return int64(0), nil
+				}
+			case "lt":
+				if !e.expireAt.IsZero() && !expireAt.Before(e.expireAt) {
+					return int64(0), nil
+				}
+			default:
+				return nil, newRedisError("ERR Unsupported option " + opt)
+			}
+		}
+
+		if !expireAt.After(time.Now()) {
+			delete(ks.data, args[0])
+			return int64(1), nil
+		}
+		e.expireAt = expireAt
+		return int64(1), nil
+	}
+}
+
+func cmdTTL(unit time.Duration) func(ks *Keyspace, args []string) (interface{}, error) {
+	return func(ks *Keyspace, args []string) (interface{}, error) {
+		e := ks.lookup(args[0])
+		if e == nil {
+			return int64(-2), nil
+		}
+		if e.expireAt.IsZero() {
+			return int64(-1), nil
+		}
+		ttl := time.Until(e.expireAt)
+		return int64((ttl + unit/2) / unit), nil
+	}
+}
+
+func cmdPersist(ks *Keyspace, args []string) (interface{}, error) {
+	e := ks.lookup(args[0])
+	if e == nil || e.expireAt.IsZero() {
+		return int64(0), nil
+	}
+	e.expireAt = time.Time{}
+	return int64(1), nil
+}
+
+func cmdGet(ks *Keyspace, args []string) (interface{}, error) {
+	s, ok, err := ks.getString(args[0])
+	if err != nil || !ok {
+		return nil, err
+	}
+	return s, nil
+}
+
+func cmdSet(ks *Keyspace, args []string) (interface{}, error) {
+	key, value := args[0], args[1]
+
+	var nx, xx, get, keepTTL bool
+	var expireAt time.Time
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToLower(args[i]); opt {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "get":
+			get = true
+		case "keepttl":
+			keepTTL = true
+		case "ex", "px", "exat", "pxat":
+			if i+1 >= len(args) || !expireAt.IsZero() {
+				return nil, errSyntax
+			}
+			i++
+			n, err := parseInt(args[i])
+			if err != nil {
+				return nil, err
+			}
+			if n <= 0 {
+				return nil, errInvalidTTL
+			}
+			switch opt {
+			case "ex":
+				expireAt = time.Now().Add(time.Duration(n) * time.Second)
+			case "px":
+				expireAt = time.Now().Add(time.Duration(n) * time.Millisecond)
+			case "exat":
+				expireAt = time.Unix(n, 0)
+			case "pxat":
+				expireAt = time.UnixMilli(n)
+			}
+		default:
+			return nil, errSyntax
+		}
+	}
+	if nx && xx {
+		return nil, errSyntax
+	}
+
+	old := ks.lookup(key)
+	var oldVal interface{}
+	if get && old != nil {
+		s, ok := old.value.(string)
+		if !ok {
+			return nil, errWrongType
+		}
+		oldVal = s
+	}
+
+	if (nx && old != nil) || (xx && old == nil) {
+		if get {
+			return oldVal, nil
+		}
+		return nil, nil
+	}
+
+	if keepTTL && old != nil {
+		expireAt = old.expireAt
+	}
+	ks.data[key] = &keyspaceEntry{value: value, expireAt: expireAt}
+
+	if get {
+		return oldVal, nil
+	}
+	return statusReply("OK"), nil
+}
+
+func cmdSetNX(ks *Keyspace, args []string) (interface{}, error) {
+	if ks.lookup(args[0]) != nil {
+		return int64(0), nil
+	}
+	ks.data[args[0]] = &keyspaceEntry{value: args[1]}
+	return int64(1), nil
+}
+
+func cmdSetEX(unit time.Duration) func(ks *Keyspace, args []string) (interface{}, error) {
+	return func(ks *Keyspace, args []string) (interface{}, error) {
+		n, err := parseInt(args[1])
+		if err != nil {
+			return nil, err
+		}
+		if n <= 0 {
+			return nil, errInvalidTTL
+		}
+		ks.data[args[0]] = &keyspaceEntry{
+			value:    args[2],
+			expireAt: time.Now().Add(time.Duration(n) * unit),
+		}
+		return statusReply("OK"), nil
+	}
+}
+
+func cmdGetSet(ks *Keyspace, args []string) (interface{}, error) {
+	s, ok, err := ks.getString(args[0])
+	if err != nil {
+		return nil, err
+	}
+	ks.data[args[0]] = &keyspaceEntry{value: args[1]}
+	if !ok {
+		return nil, nil
+	}
+	return s, nil
+}
+
+func cmdGetDel(ks *Keyspace, args []string) (interface{}, error) {
+	s, ok, err := ks.getString(args[0])
+	if err != nil || !ok {
+		return nil, err
+	}
+	delete(ks.data, args[0])
+	return s, nil
+}
+
+func cmdMGet(ks *Keyspace, args []string) (interface{}, error) {
+	vals := make([]interface{}, len(args))
+	for i, key := range args {
+		if s, ok, err := ks.getString(key); err == nil && ok {
+			vals[i] = s
+		}
+	}
+	return vals, nil
+}
+
+func cmdMSet(ks *Keyspace, args []string) (interface{}, error) {
+	if len(args)%2 != 0 {
+		return nil, newRedisError("ERR wrong number of arguments for 'mset' command")
+	}
+	for i := 0; i < len(args); i += 2 {
+		ks.data[args[i]] = &keyspaceEntry{value: args[i+1]}
+	}
+	return statusReply("OK"), nil
+}
+
+func cmdIncrBy(sign int64, hasArg bool) func(ks *Keyspace, args []string) (interface{}, error) {
+	return func(ks *Keyspace, args []string) (interface{}, error) {
+		incr := int64(1)
+		if hasArg {
+			n, err := parseInt(args[1])
+			if err != nil {
+				return nil, err
+			}
+			incr = n
+		}
+
+		s, ok, err := ks.getString(args[0])
+		if err != nil {
+			return nil, err
+		}
+		var n int64
+		if ok {
+			if n, err = parseInt(s); err != nil {
+				return nil, err
+			}
+		}
+		n += sign * incr
+
+		e := ks.lookup(args[0])
+		if e == nil {
+			ks.data[args[0]] = &keyspaceEntry{value: strconv.FormatInt(n, 10)}
+		} else {
+			e.value = strconv.FormatInt(n, 10)
+		}
+		return n, nil
+	}
+}
+
+func cmdIncrByFloat(ks *Keyspace, args []string) (interface{}, error) {
+	incr, err := parseFloat(args[1])
+	if err != nil {
+		return nil, err
+	}
+	s, ok, err := ks.getString(args[0])
+	if err != nil {
+		return nil, err
+	}
+	var f float64
+	if ok {
+		if f, err = parseFloat(s); err != nil {
+			return nil, err
+		}
+	}
+	f += incr
+
+	val := strconv.FormatFloat(f, 'f', -1, 64)
+	if e := ks.lookup(args[0]); e != nil {
+		e.value = val
+	} else {
+		ks.data[args[0]] = &keyspaceEntry{value: val}
+	}
+	return val, nil
+}
+
+func cmdAppend(ks *Keyspace, args []string) (interface{}, error) {
+	s, _, err := ks.getString(args[0])
+	if err != nil {
+		return nil, err
+	}
+	s += args[1]
+	if e := ks.lookup(args[0]); e != nil {
+		e.value = s
+	} else {
+		ks.data[args[0]] = &keyspaceEntry{value: s}
+	}
+	return int64(len(s)), nil
+}
+
+func cmdStrLen(ks *Keyspace, args []string) (interface{}, error) {
+	s, _, err := ks.getString(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return int64(len(s)), nil
+}
+
+func cmdHGet(ks *Keyspace, args []string) (interface{}, error) {
+	h, err := ks.getHash(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := h[args[1]]; ok {
+		return v, nil
+	}
+	return nil, nil
+}
+
+func cmdHSet(ks *Keyspace, args []string) (interface{}, error) {
+	if len(args)%2 != 1 {
+		return nil, newRedisError("ERR wrong number of arguments for 'hset' command")
+	}
+	h, err := ks.getHash(args[0], true)
+	if err != nil {
+		return nil, err
+	}
+	var n int64
+	for i := 1; i < len(args); i += 2 {
+		if _, ok := h[args[i]]; !ok {
+			n++
+		}
+		h[args[i]] = args[i+1]
+	}
+	return n, nil
+}
+
+func cmdHMSet(ks *Keyspace, args []string) (interface{}, error) {
+	if _, err := cmdHSet(ks, args); err != nil {
+		return nil, err
+	}
+	return statusReply("OK"), nil
+}
+
+func cmdHSetNX(ks *Keyspace, args []string) (interface{}, error) {
+	h, err := ks.getHash(args[0], true)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := h[args[1]]; ok {
+		return int64(0), nil
+	}
+	h[args[1]] = args[2]
+	return int64(1), nil
+}
+
+func cmdHDel(ks *Keyspace, args []string) (interface{}, error) {
+	h, err := ks.getHash(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	var n int64
+	for _, field := range args[1:] {
+		if _, ok := h[field]; ok {
+			delete(h, field)
+			n++
+		}
+	}
+	ks.removeIfEmpty(args[0])
+	return n, nil
+}
+
+func cmdHExists(ks *Keyspace, args []string) (interface{}, error) {
+	h, err := ks.getHash(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := h[args[1]]; ok {
+		return int64(1), nil
+	}
+	return int64(0), nil
+}
+
+func cmdHLen(ks *Keyspace, args []string) (interface{}, error) {
+	h, err := ks.getHash(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	return int64(len(h)), nil
+}
+
+func cmdHMGet(ks *Keyspace, args []string) (interface{}, error) {
+	h, err := ks.getHash(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]interface{}, len(args)-1)
+	for i, field := range args[1:] {
+		if v, ok := h[field]; ok {
+			vals[i] = v
+		}
+	}
+	return vals, nil
+}
+
+func cmdHGetAll(ks *Keyspace, args []string) (interface{}, error) {
+	h, err := ks.getHash(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]interface{}, 0, 2*len(h))
+	for _, field := range sortedKeys(h) {
+		vals = append(vals, field, h[field])
+	}
+	return vals, nil
+}
+
+func cmdHKeys(ks *Keyspace, args []string) (interface{}, error) {
+	h, err := ks.getHash(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]interface{}, 0, len(h))
+	for _, field := range sortedKeys(h) {
+		vals = append(vals, field)
+	}
+	return vals, nil
+}
+
+func cmdHVals(ks *Keyspace, args []string) (interface{}, error) {
+	h, err := ks.getHash(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]interface{}, 0, len(h))
+	for _, field := range sortedKeys(h) {
+		vals = append(vals, h[field])
+	}
+	return vals, nil
+}
+
+func cmdHIncrBy(ks *Keyspace, args []string) (interface{}, error) {
+	incr, err := parseInt(args[2])
+	if err != nil {
+		return nil, err
+	}
+	h, err := ks.getHash(args[0], true)
+	if err != nil {
+		return nil, err
+	}
+	var n int64
+	if v, ok := h[args[1]]; ok {
+		if n, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, newRedisError("ERR hash value is not an integer")
+		}
+	}
+	n += incr
+	h[args[1]] = strconv.FormatInt(n, 10)
+	return n, nil
+}
+
+func cmdHIncrByFloat(ks *Keyspace, args []string) (interface{}, error) {
+	incr, err := parseFloat(args[2])
+	if err != nil {
+		return nil, err
+	}
+	h, err := ks.getHash(args[0], true)
+	if err != nil {
+		return nil, err
+	}
+	var f float64
+	if v, ok := h[args[1]]; ok {
+		if f, err = strconv.ParseFloat(v, 64); err != nil {
+			return nil, errHashNotFloat
+		}
+	}
+	f += incr
+	h[args[1]] = strconv.FormatFloat(f, 'f', -1, 64)
+	return h[args[1]], nil
+}
+
+func cmdPush(left bool) func(ks *Keyspace, args []string) (interface{}, error) {
+	return func(ks *Keyspace, args []string) (interface{}, error) {
+		e, l, err := ks.getList(args[0])
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range args[1:] {
+			if left {
+				l = append([]string{v}, l...)
+			} else {
+				l = append(l, v)
+			}
+		}
+		if e == nil {
+			ks.data[args[0]] = &keyspaceEntry{value: l}
+		} else {
+			e.value = l
+		}
+		return int64(len(l)), nil
+	}
+}
+
+func cmdPop(left bool) func(ks *Keyspace, args []string) (interface{}, error) {
+	return func(ks *Keyspace, args []string) (interface{}, error) {
+		count := int64(-1)
+		if len(args) > 2 {
+			return nil, errSyntax
+		} else if len(args) == 2 {
+			n, err := parseInt(args[1])
+			if err != nil || n < 0 {
+				return nil, newRedisError("ERR value is out of range, must be positive")
+			}
+			count = n
+		}
+
+		e, l, err := ks.getList(args[0])
+		if err != nil || e == nil {
+			return nil, err
+		}
+
+		n := count
+		if n < 0 {
+			n = 1
+		}
+		if n > int64(len(l)) {
+			n = int64(len(l))
+		}
+
+		var popped []string
+		if left {
+			popped, l = l[:n], l[n:]
+		} else {
+			popped, l = l[int64(len(l))-n:], l[:int64(len(l))-n]
+			for i, j := 0, len(popped)-1; i < j; i, j = i+1, j-1 {
+				popped[i], popped[j] = popped[j], popped[i]
+			}
+		}
+		e.value = append([]string(nil), l...)
+		ks.removeIfEmpty(args[0])
+
+		if count < 0 {
+			return popped[0], nil
+		}
+		vals := make([]interface{}, len(popped))
+		for i, v := range popped {
+			vals[i] = v
+		}
+		return vals, nil
+	}
+}
+
+func cmdLLen(ks *Keyspace, args []string) (interface{}, error) {
+	_, l, err := ks.getList(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return int64(len(l)), nil
+}
+
+// listRange normalizes redis start/stop indexes, it returns false for an empty range.
+func listRange(start, stop, n int64) (int64, int64, bool) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop || start >= n {
+		return 0, 0, false
+	}
+	return start, stop, true
+}
+
+func cmdLRange(ks *Keyspace, args []string) (interface{}, error) {
+	start, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	stop, err := parseInt(args[2])
+	if err != nil {
+		return nil, err
+	}
+	_, l, err := ks.getList(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	vals := []interface{}{}
+	if start, stop, ok := listRange(start, stop, int64(len(l))); ok {
+		for _, v := range l[start : stop+1] {
+			vals = append(vals, v)
+		}
+	}
+	return vals, nil
+}
+
+func cmdLIndex(ks *Keyspace, args []string) (interface{}, error) {
+	i, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	_, l, err := ks.getList(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if i < 0 {
+		i += int64(len(l))
+	}
+	if i < 0 || i >= int64(len(l)) {
+		return nil, nil
+	}
+	return l[i], nil
+}
+
+func cmdLRem(ks *Keyspace, args []string) (interface{}, error) {
+	count, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	e, l, err := ks.getList(args[0])
+	if err != nil || e == nil {
+		return int64(0), err
+	}
+
+	var removed int64
+	keep := make([]string, 0, len(l))
+	if count >= 0 {
+		for _, v := range l {
+			if v == args[2] && (count == 0 || removed < count) {
+				removed++
+				continue
+			}
+			keep = append(keep, v)
+		}
+	} else {
+		for i := len(l) - 1; i >= 0; i-- {
+			if l[i] == args[2] && removed < -count {
+				removed++
+				continue
+			}
+			keep = append([]string{l[i]}, keep...)
+		}
+	}
+	e.value = keep
+	ks.removeIfEmpty(args[0])
+	return removed, nil
+}
+
+func cmdLTrim(ks *Keyspace, args []string) (interface{}, error) {
+	start, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	stop, err := parseInt(args[2])
+	if err != nil {
+		return nil, err
+	}
+	e, l, err := ks.getList(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return statusReply("OK"), nil
+	}
+	if start, stop, ok := listRange(start, stop, int64(len(l))); ok {
+		e.value = append([]string(nil), l[start:stop+1]...)
+	} else {
+		e.value = []string{}
+	}
+	ks.removeIfEmpty(args[0])
+	return statusReply("OK"), nil
+}
+
+func cmdSAdd(ks *Keyspace, args []string) (interface{}, error) {
+	s, err := ks.getSet(args[0], true)
+	if err != nil {
+		return nil, err
+	}
+	var n int64
+	for _, member := range args[1:] {
+		if _, ok := s[member]; !ok {
+			s[member] = struct{}{}
+			n++
+		}
+	}
+	return n, nil
+}
+
+func cmdSRem(ks *Keyspace, args []string) (interface{}, error) {
+	s, err := ks.getSet(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	var n int64
+	for _, member := range args[1:] {
+		if _, ok := s[member]; ok {
+			delete(s, member)
+			n++
+		}
+	}
+	ks.removeIfEmpty(args[0])
+	return n, nil
+}
+
+func cmdSIsMember(ks *Keyspace, args []string) (interface{}, error) {
+	s, err := ks.getSet(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := s[args[1]]; ok {
+		return int64(1), nil
+	}
+	return int64(0), nil
+}
+
+func cmdSMembers(ks *Keyspace, args []string) (interface{}, error) {
+	s, err := ks.getSet(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]interface{}, 0, len(s))
+	for _, member := range sortedKeys(s) {
+		vals = append(vals, member)
+	}
+	return vals, nil
+}
+
+func cmdSCard(ks *Keyspace, args []string) (interface{}, error) {
+	s, err := ks.getSet(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	return int64(len(s)), nil
+}
+
+func cmdZAdd(ks *Keyspace, args []string) (interface{}, error) {
+	var nx, xx, ch bool
+	i := 1
+loop:
+	for ; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "ch":
+			ch = true
+		default:
+			break loop
+		}
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 || (nx && xx) {
+		return nil, errSyntax
+	}
+
+	scores := make([]float64, len(pairs)/2)
+	for j := range scores {
+		f, err := parseFloat(pairs[2*j])
+		if err != nil {
+			return nil, err
+		}
+		scores[j] = f
+	}
+
+	z, err := ks.getZSet(args[0], !xx)
+	if err != nil || z == nil {
+		return int64(0), err
+	}
+
+	var added, changed int64
+	for j, score := range scores {
+		member := pairs[2*j+1]
+		old, ok := z[member]
+		switch {
+		case ok && nx, !ok && xx:
+			continue
+		case !ok:
+			added++
+		case old != score:
+			changed++
+		}
+		z[member] = score
+	}
+	ks.removeIfEmpty(args[0])
+
+	if ch {
+		return added + changed, nil
+	}
+	return added, nil
+}
+
+func cmdZIncrBy(ks *Keyspace, args []string) (interface{}, error) {
+	incr, err := parseFloat(args[1])
+	if err != nil {
+		return nil, err
+	}
+	z, err := ks.getZSet(args[0], true)
+	if err != nil {
+		return nil, err
+	}
+	z[args[2]] += incr
+	return formatFloat(z[args[2]]), nil
+}
+
+func cmdZRem(ks *Keyspace, args []string) (interface{}, error) {
+	z, err := ks.getZSet(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	var n int64
+	for _, member := range args[1:] {
+		if _, ok := z[member]; ok {
+			delete(z, member)
+			n++
+		}
+	}
+	ks.removeIfEmpty(args[0])
+	return n, nil
+}
+
+func cmdZScore(ks *Keyspace, args []string) (interface{}, error) {
+	z, err := ks.getZSet(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	if score, ok := z[args[1]]; ok {
+		return formatFloat(score), nil
+	}
+	return nil, nil
+}
+
+func cmdZCard(ks *Keyspace, args []string) (interface{}, error) {
+	z, err := ks.getZSet(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	return int64(len(z)), nil
+}
+
+// scoreBound is a ZRANGEBYSCORE min/max argument, e.g. "-inf", "(1.5" or "10".
+type scoreBound struct {
+	value     float64
+	exclusive bool
+}
+
+func parseScoreBound(s string) (scoreBound, error) {
+	var b scoreBound
+	if strings.HasPrefix(s, "(") {
+		b.exclusive = true
+		s = s[1:]
+	}
+	switch strings.ToLower(s) {
+	case "-inf":
+		b.value = math.Inf(-1)
+	case "+inf", "inf":
+		b.value = math.Inf(1)
+	default:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return b, errMinMaxFloat
+		}
+		b.value = f
+	}
+	return b, nil
+}
+
+func (b scoreBound) aboveMin(score float64) bool {
+	if b.exclusive {
+		return score > b.value
+	}
+	return score >= b.value
+}
+
+func (b scoreBound) belowMax(score float64) bool {
+	if b.exclusive {
+		return score < b.value
+	}
+	return score <= b.value
+}
+
+type zMember struct {
+	member string
+	score  float64
+}
+
+// sortedZSet returns the members ordered by score, then lexicographically.
+func sortedZSet(z map[string]float64) []zMember {
+	members := make([]zMember, 0, len(z))
+	for member, score := range z {
+		members = append(members, zMember{member: member, score: score})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].score != members[j].score {
+			return members[i].score < members[j].score
+		}
+		return members[i].member < members[j].member
+	})
+	return members
+}
+
+func (ks *Keyspace) zRangeByScore(key, min, max string) ([]zMember, error) {
+	lo, err := parseScoreBound(min)
+	if err != nil {
+		return nil, err
+	}
+	hi, err := parseScoreBound(max)
+	if err != nil {
+		return nil, err
+	}
+	z, err := ks.getZSet(key, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var members []zMember
+	for _, m := range sortedZSet(z) {
+		if lo.aboveMin(m.score) && hi.belowMax(m.score) {
+			members = append(members, m)
+		}
+	}
+	return members, nil
+}
+
+func zReply(members []zMember, withScores bool) []interface{} {
+	vals := make([]interface{}, 0, len(members))
+	for _, m := range members {
+		vals = append(vals, m.member)
+		if withScores {
+			vals = append(vals, formatFloat(m.score))
+		}
+	}
+	return vals
+}
+
+func cmdZCount(ks *Keyspace, args []string) (interface{}, error) {
+	members, err := ks.zRangeByScore(args[0], args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	return int64(len(members)), nil
+}
+
+func cmdZRange(ks *Keyspace, args []string) (interface{}, error) {
+	start, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	stop, err := parseInt(args[2])
+	if err != nil {
+		return nil, err
+	}
+	var withScores bool
+	for _, opt := range args[3:] {
+		if strings.ToLower(opt) != "withscores" {
+			return nil, errSyntax
+		}
+		withScores = true
+	}
+
+	z, err := ks.getZSet(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	members := sortedZSet(z)
+	if start, stop, ok := listRange(start, stop, int64(len(members))); ok {
+		return zReply(members[start:stop+1], withScores), nil
+	}
+	return []interface{}{}, nil
+}
+
+func cmdZRangeByScore(ks *Keyspace, args []string) (interface{}, error) {
+	var withScores bool
+	offset, count := int64(0), int64(-1)
+	for i := 3; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "withscores":
+			withScores = true
+		case "limit":
+			if i+2 >= len(args) {
+				return nil, errSyntax
+			}
+			var err error
+			if offset, err = parseInt(args[i+1]); err != nil {
+				return nil, err
+			}
+			if count, err = parseInt(args[i+2]); err != nil {
+				return nil, err
+			}
+			i += 2
+		default:
+			return nil, errSyntax
+		}
+	}
+
+	members, err := ks.zRangeByScore(args[0], args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 || offset >= int64(len(members)) {
+		return []interface{}{}, nil
+	}
+	members = members[offset:]
+	if count >= 0 && count < int64(len(members)) {
+		members = members[:count]
+	}
+	return zReply(members, withScores), nil
+}
+
+func cmdZRemRangeByScore(ks *Keyspace, args []string) (interface{}, error) {
+	members, err := ks.zRangeByScore(args[0], args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return int64(0), nil
+	}
+	z, _ := ks.getZSet(args[0], false)
+	for _, m := range members {
+		delete(z, m.member)
+	}
+	ks.removeIfEmpty(args[0])
+	return int64(len(members)), nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
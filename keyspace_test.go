@@ -0,0 +1,204 @@
+package redismock
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+// ksCmd is a command sent to Keyspace.Do, ksSeed the commands run before it.
+func ksCmd(args ...interface{}) []interface{} {
+	return args
+}
+
+func ksSeed(cmds ...[]interface{}) [][]interface{} {
+	return cmds
+}
+
+var _ = Describe("Keyspace", func() {
+
+	table.DescribeTable("Do",
+		func(seed [][]interface{}, cmd []interface{}, want interface{}) {
+			ks := NewKeyspace()
+			for _, args := range seed {
+				_, err := ks.Do(args...)
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			val, err := ks.Do(cmd...)
+			if wantErr, ok := want.(error); ok {
+				Expect(err).To(MatchError(wantErr.Error()))
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			if want == nil {
+				Expect(val).To(BeNil())
+				return
+			}
+			Expect(val).To(Equal(want))
+		},
+
+		// generic
+		table.Entry("ping", nil, ksCmd("ping"), "PONG"),
+		table.Entry("ping message", nil, ksCmd("ping", "hi"), "hi"),
+		table.Entry("unknown command", nil, ksCmd("lmove", "a", "b"), errors.New("ERR unknown command 'lmove'")),
+		table.Entry("wrong number of arguments", nil, ksCmd("get"), errors.New("ERR wrong number of arguments for 'get' command")),
+		table.Entry("del", ksSeed(ksCmd("set", "a", "1"), ksCmd("set", "b", "2")), ksCmd("del", "a", "b", "c"), int64(2)),
+		table.Entry("unlink", ksSeed(ksCmd("set", "a", "1")), ksCmd("unlink", "a"), int64(1)),
+		table.Entry("exists", ksSeed(ksCmd("set", "a", "1")), ksCmd("exists", "a", "a", "b"), int64(2)),
+		table.Entry("type none", nil, ksCmd("type", "k"), "none"),
+		table.Entry("type string", ksSeed(ksCmd("set", "k", "v")), ksCmd("type", "k"), "string"),
+		table.Entry("type hash", ksSeed(ksCmd("hset", "k", "f", "v")), ksCmd("type", "k"), "hash"),
+		table.Entry("type list", ksSeed(ksCmd("rpush", "k", "v")), ksCmd("type", "k"), "list"),
+		table.Entry("type set", ksSeed(ksCmd("sadd", "k", "v")), ksCmd("type", "k"), "set"),
+		table.Entry("type zset", ksSeed(ksCmd("zadd", "k", 1, "v")), ksCmd("type", "k"), "zset"),
+
+		// expiration
+		table.Entry("expire", ksSeed(ksCmd("set", "k", "v")), ksCmd("expire", "k", 100), int64(1)),
+		table.Entry("expire missing", nil, ksCmd("expire", "k", 100), int64(0)),
+		table.Entry("expire nx", ksSeed(ksCmd("set", "k", "v", "ex", 10)), ksCmd("expire", "k", 100, "nx"), int64(0)),
+		table.Entry("expire xx", ksSeed(ksCmd("set", "k", "v")), ksCmd("expire", "k", 100, "xx"), int64(0)),
+		table.Entry("expire gt", ksSeed(ksCmd("set", "k", "v", "ex", 10)), ksCmd("expire", "k", 5, "gt"), int64(0)),
+		table.Entry("expire lt without ttl", ksSeed(ksCmd("set", "k", "v")), ksCmd("expire", "k", 5, "lt"), int64(1)),
+		table.Entry("ttl", ksSeed(ksCmd("set", "k", "v"), ksCmd("expire", "k", 100)), ksCmd("ttl", "k"), int64(100)),
+		table.Entry("ttl without expire", ksSeed(ksCmd("set", "k", "v")), ksCmd("ttl", "k"), int64(-1)),
+		table.Entry("ttl missing", nil, ksCmd("ttl", "k"), int64(-2)),
+		table.Entry("pttl", ksSeed(ksCmd("set", "k", "v"), ksCmd("pexpire", "k", 5000)), ksCmd("pttl", "k"), int64(5000)),
+		table.Entry("expireat in the past", ksSeed(ksCmd("set", "k", "v"), ksCmd("expireat", "k", 1)), ksCmd("exists", "k"), int64(0)),
+		table.Entry("pexpireat", ksSeed(ksCmd("set", "k", "v"), ksCmd("pexpireat", "k", int64(32503680000000))), ksCmd("persist", "k"), int64(1)),
+		table.Entry("persist", ksSeed(ksCmd("set", "k", "v", "ex", 10)), ksCmd("persist", "k"), int64(1)),
+		table.Entry("persist without ttl", ksSeed(ksCmd("set", "k", "v")), ksCmd("persist", "k"), int64(0)),
+		table.Entry("ttl after persist", ksSeed(ksCmd("set", "k", "v", "ex", 10), ksCmd("persist", "k")), ksCmd("ttl", "k"), int64(-1)),
+
+		// strings
+		table.Entry("get", ksSeed(ksCmd("set", "k", "v")), ksCmd("get", "k"), "v"),
+		table.Entry("get missing", nil, ksCmd("get", "k"), nil),
+		table.Entry("get wrong type", ksSeed(ksCmd("hset", "k", "f", "v")), ksCmd("get", "k"),
+			errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")),
+		table.Entry("set", nil, ksCmd("set", "k", "v"), "OK"),
+		table.Entry("set nx existing", ksSeed(ksCmd("set", "k", "v")), ksCmd("set", "k", "w", "nx"), nil),
+		table.Entry("set xx missing", nil, ksCmd("set", "k", "w", "xx"), nil),
+		table.Entry("set get", ksSeed(ksCmd("set", "k", "v")), ksCmd("set", "k", "w", "get"), "v"),
+		table.Entry("set ex", ksSeed(ksCmd("set", "k", "v", "ex", 10)), ksCmd("ttl", "k"), int64(10)),
+		table.Entry("set keepttl", ksSeed(ksCmd("set", "k", "v", "ex", 10), ksCmd("set", "k", "w", "keepttl")), ksCmd("ttl", "k"), int64(10)),
+		table.Entry("set invalid expire", nil, ksCmd("set", "k", "v", "ex", 0), errors.New("ERR invalid expire time in 'set' command")),
+		table.Entry("set nx and xx", nil, ksCmd("set", "k", "v", "nx", "xx"), errors.New("ERR syntax error")),
+		table.Entry("setnx", nil, ksCmd("setnx", "k", "v"), int64(1)),
+		table.Entry("setnx existing", ksSeed(ksCmd("set", "k", "v")), ksCmd("setnx", "k", "w"), int64(0)),
+		table.Entry("setex", ksSeed(ksCmd("setex", "k", 10, "v")), ksCmd("ttl", "k"), int64(10)),
+		table.Entry("psetex", ksSeed(ksCmd("psetex", "k", 1500, "v")), ksCmd("pttl", "k"), int64(1500)),
+		table.Entry("getset", ksSeed(ksCmd("set", "k", "old")), ksCmd("getset", "k", "new"), "old"),
+		table.Entry("getset missing", nil, ksCmd("getset", "k", "new"), nil),
+		table.Entry("getdel", ksSeed(ksCmd("set", "k", "v")), ksCmd("getdel", "k"), "v"),
+		table.Entry("getdel removes the key", ksSeed(ksCmd("set", "k", "v"), ksCmd("getdel", "k")), ksCmd("exists", "k"), int64(0)),
+		table.Entry("mget", ksSeed(ksCmd("set", "a", "1"), ksCmd("hset", "h", "f", "v")), ksCmd("mget", "a", "b", "h"), []interface{}{"1", nil, nil}),
+		table.Entry("mset", ksSeed(ksCmd("mset", "a", "1", "b", "2")), ksCmd("mget", "a", "b"), []interface{}{"1", "2"}),
+		table.Entry("mset odd arguments", nil, ksCmd("mset", "a", "1", "b"), errors.New("ERR wrong number of arguments for 'mset' command")),
+		table.Entry("incr", nil, ksCmd("incr", "k"), int64(1)),
+		table.Entry("decr", ksSeed(ksCmd("set", "k", "5")), ksCmd("decr", "k"), int64(4)),
+		table.Entry("incrby", ksSeed(ksCmd("set", "k", "5")), ksCmd("incrby", "k", 10), int64(15)),
+		table.Entry("decrby", ksSeed(ksCmd("set", "k", "5")), ksCmd("decrby", "k", 10), int64(-5)),
+		table.Entry("incr not an integer", ksSeed(ksCmd("set", "k", "v")), ksCmd("incr", "k"), errors.New("ERR value is not an integer or out of range")),
+		table.Entry("incrbyfloat", ksSeed(ksCmd("set", "k", "10.5")), ksCmd("incrbyfloat", "k", "0.25"), "10.75"),
+		table.Entry("incrbyfloat not a float", ksSeed(ksCmd("set", "k", "v")), ksCmd("incrbyfloat", "k", 1), errors.New("ERR value is not a valid float")),
+		table.Entry("append", ksSeed(ksCmd("set", "k", "foo")), ksCmd("append", "k", "bar"), int64(6)),
+		table.Entry("append missing", nil, ksCmd("append", "k", "bar"), int64(3)),
+		table.Entry("strlen", ksSeed(ksCmd("set", "k", "foo")), ksCmd("strlen", "k"), int64(3)),
+
+		// hashes
+		table.Entry("hget", ksSeed(ksCmd("hset", "h", "f", "v")), ksCmd("hget", "h", "f"), "v"),
+		table.Entry("hget missing field", ksSeed(ksCmd("hset", "h", "f", "v")), ksCmd("hget", "h", "g"), nil),
+		table.Entry("hset", ksSeed(ksCmd("hset", "h", "a", "1")), ksCmd("hset", "h", "a", "2", "b", "3"), int64(1)),
+		table.Entry("hset odd arguments", nil, ksCmd("hset", "h", "a", "1", "b"), errors.New("ERR wrong number of arguments for 'hset' command")),
+		table.Entry("hmset", nil, ksCmd("hmset", "h", "a", "1"), "OK"),
+		table.Entry("hsetnx", ksSeed(ksCmd("hset", "h", "a", "1")), ksCmd("hsetnx", "h", "a", "2"), int64(0)),
+		table.Entry("hdel", ksSeed(ksCmd("hset", "h", "a", "1", "b", "2")), ksCmd("hdel", "h", "a", "c"), int64(1)),
+		table.Entry("hdel removes the empty hash", ksSeed(ksCmd("hset", "h", "a", "1"), ksCmd("hdel", "h", "a")), ksCmd("exists", "h"), int64(0)),
+		table.Entry("hexists", ksSeed(ksCmd("hset", "h", "a", "1")), ksCmd("hexists", "h", "a"), int64(1)),
+		table.Entry("hlen", ksSeed(ksCmd("hset", "h", "a", "1", "b", "2")), ksCmd("hlen", "h"), int64(2)),
+		table.Entry("hmget", ksSeed(ksCmd("hset", "h", "a", "1")), ksCmd("hmget", "h", "a", "b"), []interface{}{"1", nil}),
+		table.Entry("hgetall", ksSeed(ksCmd("hset", "h", "b", "2", "a", "1")), ksCmd("hgetall", "h"), []interface{}{"a", "1", "b", "2"}),
+		table.Entry("hgetall missing", nil, ksCmd("hgetall", "h"), []interface{}{}),
+		table.Entry("hkeys", ksSeed(ksCmd("hset", "h", "b", "2", "a", "1")), ksCmd("hkeys", "h"), []interface{}{"a", "b"}),
+		table.Entry("hvals", ksSeed(ksCmd("hset", "h", "b", "2", "a", "1")), ksCmd("hvals", "h"), []interface{}{"1", "2"}),
+		table.Entry("hincrby", ksSeed(ksCmd("hset", "h", "n", "1")), ksCmd("hincrby", "h", "n", 5), int64(6)),
+		table.Entry("hincrby not an integer", ksSeed(ksCmd("hset", "h", "n", "x")), ksCmd("hincrby", "h", "n", 5), errors.New("ERR hash value is not an integer")),
+		table.Entry("hincrbyfloat", ksSeed(ksCmd("hset", "h", "n", "1")), ksCmd("hincrbyfloat", "h", "n", "1.5"), "2.5"),
+		table.Entry("hincrbyfloat not a float", ksSeed(ksCmd("hset", "h", "n", "x")), ksCmd("hincrbyfloat", "h", "n", 1), errors.New("ERR hash value is not a float")),
+
+		// lists
+		table.Entry("lpush", ksSeed(ksCmd("lpush", "l", "a", "b")), ksCmd("lrange", "l", 0, -1), []interface{}{"b", "a"}),
+		table.Entry("rpush", nil, ksCmd("rpush", "l", "a", "b"), int64(2)),
+		table.Entry("lpop", ksSeed(ksCmd("rpush", "l", "a", "b", "c")), ksCmd("lpop", "l"), "a"),
+		table.Entry("lpop count", ksSeed(ksCmd("rpush", "l", "a", "b", "c")), ksCmd("lpop", "l", 2), []interface{}{"a", "b"}),
+		table.Entry("rpop", ksSeed(ksCmd("rpush", "l", "a", "b", "c")), ksCmd("rpop", "l"), "c"),
+		table.Entry("rpop count", ksSeed(ksCmd("rpush", "l", "a", "b", "c")), ksCmd("rpop", "l", 2), []interface{}{"c", "b"}),
+		table.Entry("pop missing", nil, ksCmd("lpop", "l"), nil),
+		table.Entry("llen", ksSeed(ksCmd("rpush", "l", "a", "b")), ksCmd("llen", "l"), int64(2)),
+		table.Entry("lrange", ksSeed(ksCmd("rpush", "l", "a", "b", "c")), ksCmd("lrange", "l", 1, 5), []interface{}{"b", "c"}),
+		table.Entry("lrange negative", ksSeed(ksCmd("rpush", "l", "a", "b", "c")), ksCmd("lrange", "l", -2, -1), []interface{}{"b", "c"}),
+		table.Entry("lrange empty", ksSeed(ksCmd("rpush", "l", "a", "b", "c")), ksCmd("lrange", "l", 2, 1), []interface{}{}),
+		table.Entry("lindex", ksSeed(ksCmd("rpush", "l", "a", "b", "c")), ksCmd("lindex", "l", -1), "c"),
+		table.Entry("lindex out of range", ksSeed(ksCmd("rpush", "l", "a", "b", "c")), ksCmd("lindex", "l", 5), nil),
+		table.Entry("lrem head", ksSeed(ksCmd("rpush", "l", "a", "b", "a", "c", "a"), ksCmd("lrem", "l", 2, "a")), ksCmd("lrange", "l", 0, -1), []interface{}{"b", "c", "a"}),
+		table.Entry("lrem tail", ksSeed(ksCmd("rpush", "l", "a", "b", "a", "c", "a"), ksCmd("lrem", "l", -2, "a")), ksCmd("lrange", "l", 0, -1), []interface{}{"a", "b", "c"}),
+		table.Entry("lrem all", ksSeed(ksCmd("rpush", "l", "a", "b", "a", "c", "a")), ksCmd("lrem", "l", 0, "a"), int64(3)),
+		table.Entry("ltrim", ksSeed(ksCmd("rpush", "l", "a", "b", "c")), ksCmd("ltrim", "l", 1, -1), "OK"),
+		table.Entry("ltrim keeps the range", ksSeed(ksCmd("rpush", "l", "a", "b", "c"), ksCmd("ltrim", "l", 1, -1)), ksCmd("lrange", "l", 0, -1), []interface{}{"b", "c"}),
+		table.Entry("ltrim empty range removes the list", ksSeed(ksCmd("rpush", "l", "a"), ksCmd("ltrim", "l", 1, 0)), ksCmd("exists", "l"), int64(0)),
+
+		// sets
+		table.Entry("sadd", ksSeed(ksCmd("sadd", "s", "a")), ksCmd("sadd", "s", "a", "b"), int64(1)),
+		table.Entry("srem", ksSeed(ksCmd("sadd", "s", "a", "b")), ksCmd("srem", "s", "a", "c"), int64(1)),
+		table.Entry("sismember", ksSeed(ksCmd("sadd", "s", "a")), ksCmd("sismember", "s", "a"), int64(1)),
+		table.Entry("sismember missing", nil, ksCmd("sismember", "s", "a"), int64(0)),
+		table.Entry("smembers", ksSeed(ksCmd("sadd", "s", "b", "a")), ksCmd("smembers", "s"), []interface{}{"a", "b"}),
+		table.Entry("scard", ksSeed(ksCmd("sadd", "s", "a", "b")), ksCmd("scard", "s"), int64(2)),
+
+		// sorted sets
+		table.Entry("zadd", nil, ksCmd("zadd", "z", 1, "a", 2, "b"), int64(2)),
+		table.Entry("zadd nx", ksSeed(ksCmd("zadd", "z", 1, "a")), ksCmd("zadd", "z", "nx", 5, "a", 3, "c"), int64(1)),
+		table.Entry("zadd xx ch", ksSeed(ksCmd("zadd", "z", 1, "a")), ksCmd("zadd", "z", "xx", "ch", 5, "a", 1, "c"), int64(1)),
+		table.Entry("zadd not a float", nil, ksCmd("zadd", "z", "x", "a"), errors.New("ERR value is not a valid float")),
+		table.Entry("zincrby", ksSeed(ksCmd("zadd", "z", 1, "a")), ksCmd("zincrby", "z", "2.5", "a"), "3.5"),
+		table.Entry("zrem", ksSeed(ksCmd("zadd", "z", 1, "a", 2, "b")), ksCmd("zrem", "z", "a", "c"), int64(1)),
+		table.Entry("zscore", ksSeed(ksCmd("zadd", "z", 1, "a")), ksCmd("zscore", "z", "a"), "1"),
+		table.Entry("zscore missing", nil, ksCmd("zscore", "z", "a"), nil),
+		table.Entry("zcard", ksSeed(ksCmd("zadd", "z", 1, "a", 2, "b")), ksCmd("zcard", "z"), int64(2)),
+		table.Entry("zcount", ksSeed(ksCmd("zadd", "z", 1, "a", 2, "b", 3, "c")), ksCmd("zcount", "z", "(1", 3), int64(2)),
+		table.Entry("zcount invalid bound", nil, ksCmd("zcount", "z", "x", 3), errors.New("ERR min or max is not a float")),
+		table.Entry("zrange", ksSeed(ksCmd("zadd", "z", 2, "b", 1, "a", 1, "c")), ksCmd("zrange", "z", 0, -1), []interface{}{"a", "c", "b"}),
+		table.Entry("zrange withscores", ksSeed(ksCmd("zadd", "z", 2, "b", 1.5, "a")), ksCmd("zrange", "z", 0, -1, "withscores"),
+			[]interface{}{"a", "1.5", "b", "2"}),
+		table.Entry("zrangebyscore", ksSeed(ksCmd("zadd", "z", 1, "a", 2, "b", 3, "c")), ksCmd("zrangebyscore", "z", "-inf", "(3"), []interface{}{"a", "b"}),
+		table.Entry("zrangebyscore limit", ksSeed(ksCmd("zadd", "z", 1, "a", 2, "b", 3, "c")), ksCmd("zrangebyscore", "z", "-inf", "+inf", "limit", 1, 1),
+			[]interface{}{"b"}),
+		table.Entry("zremrangebyscore", ksSeed(ksCmd("zadd", "z", 1, "a", 2, "b", 3, "c")), ksCmd("zremrangebyscore", "z", 2, "+inf"), int64(2)),
+		table.Entry("zremrangebyscore keeps the others", ksSeed(ksCmd("zadd", "z", 1, "a", 2, "b", 3, "c"), ksCmd("zremrangebyscore", "z", 2, "+inf")),
+			ksCmd("zrange", "z", 0, -1), []interface{}{"a"}),
+	)
+
+	It("time", func() {
+		val, err := NewKeyspace().Do("time")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(val).To(HaveLen(2))
+
+		sec, err := strconv.ParseInt(val.([]interface{})[0].(string), 10, 64)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(time.Unix(sec, 0)).To(BeTemporally("~", time.Now(), 2*time.Second))
+	})
+
+	It("expired keys", func() {
+		ks := NewKeyspace()
+		_, err := ks.Do("set", "k", "v", "px", 10)
+		Expect(err).NotTo(HaveOccurred())
+
+		time.Sleep(20 * time.Millisecond)
+		Expect(ks.Do("get", "k")).To(BeNil())
+		Expect(ks.Do("exists", "k")).To(Equal(int64(0)))
+	})
+})
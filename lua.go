@@ -0,0 +1,600 @@
+package redismock
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+	lua "github.com/yuin/gopher-lua"
+)
+
+var (
+	errNoScript       = newRedisError("NOSCRIPT No matching script. Please use EVAL.")
+	errReadOnlyScript = newRedisError("ERR Write commands are not allowed from read-only scripts.")
+	errFunctionWrite  = newRedisError("ERR Can not execute a script with write flag using *_ro command.")
+	errNoFunction     = newRedisError("ERR Function not found")
+	errNoLibrary      = newRedisError("ERR Library not found")
+
+	libraryMetadata = regexp.MustCompile(`^#!(\w+)([^\n]*)`)
+)
+
+// scriptEngine executes EVAL/EVALSHA/FCALL commands in an embedded Lua VM,
+// redis.call and redis.pcall operate on the keyspace.
+type scriptEngine struct {
+	ks *Keyspace
+
+	// functions is the library registry of the mock
+	functions *functionState
+
+	mu      sync.Mutex
+	scripts map[string]string
+}
+
+type luaLibrary struct {
+	name      string
+	code      string
+	functions map[string]luaFunction
+}
+
+type luaFunction struct {
+	callback *lua.LFunction
+	noWrites bool
+}
+
+func newScriptEngine(ks *Keyspace, functions *functionState) *scriptEngine {
+	return &scriptEngine{
+		ks:        ks,
+		functions: functions,
+		scripts:   make(map[string]string),
+	}
+}
+
+// handles reports whether cmd is executed by the engine.
+func (se *scriptEngine) handles(cmd redis.Cmder) bool {
+	switch cmd.Name() {
+	case "eval", "eval_ro", "evalsha", "evalsha_ro", "fcall", "fcall_ro":
+		return true
+	case "script":
+		switch subCommand(cmd) {
+		case "load", "exists", "flush":
+			return true
+		}
+	case "function":
+		switch subCommand(cmd) {
+		case "load", "delete", "flush":
+			return true
+		}
+	}
+	return false
+}
+
+func subCommand(cmd redis.Cmder) string {
+	args := cmd.Args()
+	if len(args) < 2 {
+		return ""
+	}
+	return strings.ToLower(fmt.Sprint(args[1]))
+}
+
+func (se *scriptEngine) process(cmd redis.Cmder) error {
+	args, err := wireArgs(cmd.Args())
+	if err != nil {
+		cmd.SetErr(err)
+		return err
+	}
+
+	var val interface{}
+	switch name := cmd.Name(); name {
+	case "eval", "eval_ro":
+		if len(args) < 3 {
+			err = wrongArgs(name)
+			break
+		}
+		sha := sha1hex(args[1])
+		se.mu.Lock()
+		se.scripts[sha] = args[1]
+		se.mu.Unlock()
+		val, err = se.eval(args[1], args[2:], name == "eval_ro")
+	case "evalsha", "evalsha_ro":
+		if len(args) < 3 {
+			err = wrongArgs(name)
+			break
+		}
+		se.mu.Lock()
+		src, ok := se.scripts[strings.ToLower(args[1])]
+		se.mu.Unlock()
+		if !ok {
+			err = errNoScript
+			break
+		}
+		val, err = se.eval(src, args[2:], name == "evalsha_ro")
+	case "fcall", "fcall_ro":
+		if len(args) < 3 {
+			err = wrongArgs(name)
+			break
+		}
+		val, err = se.fcall(args[1], args[2:], name == "fcall_ro")
+	case "script":
+		val, err = se.script(args[1:])
+	case "function":
+		val, err = se.function(args[1:])
+	}
+
+	if err == nil && val == nil {
+		err = redis.Nil
+	}
+	if err != nil {
+		cmd.SetErr(err)
+		return err
+	}
+	return setReply(cmd, goReply(val))
+}
+
+// setReply writes a keyspace reply into the command returned by go-redis.
+func setReply(cmd redis.Cmder, val interface{}) error {
+	switch cmd := cmd.(type) {
+	case *redis.Cmd:
+		cmd.SetVal(val)
+	case *wireCmd:
+		cmd.SetVal(val)
+	case *redis.StringCmd:
+		cmd.SetVal(fmt.Sprint(val))
+	case *redis.StatusCmd:
+		cmd.SetVal(fmt.Sprint(val))
+	case *redis.BoolSliceCmd:
+		vals, _ := val.([]interface{})
+		bs := make([]bool, len(vals))
+		for i := range vals {
+			bs[i] = vals[i] == int64(1)
+		}
+		cmd.SetVal(bs)
+	default:
+		err := fmt.Errorf("cmd(%s), script reply can not be written into %T", cmd.Name(), cmd)
+		cmd.SetErr(err)
+		return err
+	}
+	cmd.SetErr(nil)
+	return nil
+}
+
+func wrongArgs(name string) error {
+	return newRedisError("ERR wrong number of arguments for '" + name + "' command")
+}
+
+func sha1hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// splitKeys parses "numkeys key [key ...] arg [arg ...]".
+func splitKeys(args []string) (keys, argv []string, err error) {
+	n, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return nil, nil, errNotInteger
+	}
+	if n < 0 {
+		return nil, nil, newRedisError("ERR Number of keys can't be negative")
+	}
+	if n > int64(len(args)-1) {
+		return nil, nil, newRedisError("ERR Number of keys can't be greater than number of args")
+	}
+	return args[1 : n+1], args[n+1:], nil
+}
+
+func (se *scriptEngine) eval(src string, args []string, readOnly bool) (interface{}, error) {
+	keys, argv, err := splitKeys(args)
+	if err != nil {
+		return nil, err
+	}
+
+	L := newLuaState(se.ks, nil)
+	defer L.Close()
+	setReadOnly(L, readOnly)
+
+	fn, err := L.Load(strings.NewReader(src), "user_script")
+	if err != nil {
+		return nil, newRedisError("ERR Error compiling script (new function): " + err.Error())
+	}
+	L.SetGlobal("KEYS", stringsTable(L, keys))
+	L.SetGlobal("ARGV", stringsTable(L, argv))
+
+	se.ks.mu.Lock()
+	defer se.ks.mu.Unlock()
+
+	L.Push(fn)
+	return runScript(L, 0)
+}
+
+func (se *scriptEngine) fcall(function string, args []string, readOnly bool) (interface{}, error) {
+	keys, argv, err := splitKeys(args)
+	if err != nil {
+		return nil, err
+	}
+
+	lib := se.functions.library(function)
+	if lib == nil {
+		return nil, errNoFunction
+	}
+
+	// the library is loaded again, callbacks are bound to the state that registered them
+	fns := make(map[string]luaFunction)
+	L := newLuaState(se.ks, fns)
+	defer L.Close()
+	if err := loadLibrary(L, lib.code); err != nil {
+		return nil, err
+	}
+	fn := fns[function]
+	if readOnly && !fn.noWrites {
+		return nil, errFunctionWrite
+	}
+	setReadOnly(L, readOnly || fn.noWrites)
+
+	se.ks.mu.Lock()
+	defer se.ks.mu.Unlock()
+
+	L.Push(fn.callback)
+	L.Push(stringsTable(L, keys))
+	L.Push(stringsTable(L, argv))
+	return runScript(L, 2)
+}
+
+func (se *scriptEngine) script(args []string) (interface{}, error) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+
+	switch sub := strings.ToLower(args[0]); sub {
+	case "load":
+		if len(args) != 2 {
+			return nil, wrongArgs("script|load")
+		}
+		L := lua.NewState()
+		_, err := L.Load(strings.NewReader(args[1]), "user_script")
+		L.Close()
+		if err != nil {
+			return nil, newRedisError("ERR Error compiling script (new function): " + err.Error())
+		}
+		sha := sha1hex(args[1])
+		se.scripts[sha] = args[1]
+		return sha, nil
+	case "exists":
+		vals := make([]interface{}, len(args)-1)
+		for i, sha := range args[1:] {
+			vals[i] = int64(0)
+			if _, ok := se.scripts[strings.ToLower(sha)]; ok {
+				vals[i] = int64(1)
+			}
+		}
+		return vals, nil
+	default:
+		se.scripts = make(map[string]string)
+		return statusReply("OK"), nil
+	}
+}
+
+func (se *scriptEngine) function(args []string) (interface{}, error) {
+	switch sub := strings.ToLower(args[0]); sub {
+	case "load":
+		replace := len(args) > 2 && strings.ToLower(args[1]) == "replace"
+		if len(args) < 2 || (len(args) > 2 && !replace) || len(args) > 3 {
+			return nil, wrongArgs("function|load")
+		}
+		return se.functions.load(args[len(args)-1], replace)
+	case "delete":
+		if len(args) != 2 {
+			return nil, wrongArgs("function|delete")
+		}
+		if !se.functions.remove(args[1]) {
+			return nil, errNoLibrary
+		}
+		return statusReply("OK"), nil
+	default:
+		se.functions.flush()
+		return statusReply("OK"), nil
+	}
+}
+
+// parseLibrary reads the metadata of a library and the functions it registers.
+func parseLibrary(code string) (*luaLibrary, error) {
+	meta := libraryMetadata.FindStringSubmatch(code)
+	if meta == nil {
+		return nil, newRedisError("ERR Missing library metadata")
+	}
+	if meta[1] != "lua" {
+		return nil, newRedisError("ERR Engine '" + meta[1] + "' not found")
+	}
+	var name string
+	for _, field := range strings.Fields(meta[2]) {
+		if strings.HasPrefix(field, "name=") {
+			name = strings.TrimPrefix(field, "name=")
+		} else {
+			return nil, newRedisError("ERR Invalid metadata value given: " + field)
+		}
+	}
+	if name == "" {
+		return nil, newRedisError("ERR Library name was not given")
+	}
+
+	// like redis-server, redis.call is not available while loading
+	fns := make(map[string]luaFunction)
+	L := newLuaState(nil, fns)
+	defer L.Close()
+	if err := loadLibrary(L, code); err != nil {
+		return nil, err
+	}
+	if len(fns) == 0 {
+		return nil, newRedisError("ERR No functions registered")
+	}
+
+	// callbacks can not outlive the state, only the names and flags are kept
+	functions := make(map[string]luaFunction, len(fns))
+	for fn, f := range fns {
+		functions[fn] = luaFunction{noWrites: f.noWrites}
+	}
+	return &luaLibrary{name: name, code: code, functions: functions}, nil
+}
+
+// loadLibrary runs the library code, registering its functions.
+func loadLibrary(L *lua.LState, code string) error {
+	// the shebang line is not valid lua
+	fn, err := L.Load(strings.NewReader("--"+code), "user_function")
+	if err != nil {
+		return newRedisError("ERR Error compiling function: " + err.Error())
+	}
+	L.Push(fn)
+	if err := L.PCall(0, 0, nil); err != nil {
+		return newRedisError("ERR Error registering functions: " + luaErrorMessage(err))
+	}
+	return nil
+}
+
+// ------------------------------------------------------------
+
+// newLuaState returns a Lua state with the libraries and the redis table available to scripts,
+// redis.call and redis.pcall are only set if ks is not nil.
+// If fns is not nil, redis.register_function adds the functions of a library into it.
+func newLuaState(ks *Keyspace, fns map[string]luaFunction) *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		fn   lua.LGFunction
+	}{
+		{lua.LoadLibName, lua.OpenPackage},
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.fn))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "require", "module"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	mod := L.NewTable()
+	L.SetFuncs(mod, map[string]lua.LGFunction{
+		"status_reply": func(L *lua.LState) int {
+			L.Push(replyTable(L, "ok", L.CheckString(1)))
+			return 1
+		},
+		"error_reply": func(L *lua.LState) int {
+			L.Push(replyTable(L, "err", L.CheckString(1)))
+			return 1
+		},
+		"sha1hex": func(L *lua.LState) int {
+			L.Push(lua.LString(sha1hex(L.CheckString(1))))
+			return 1
+		},
+		"log": func(L *lua.LState) int {
+			return 0
+		},
+		"setresp": func(L *lua.LState) int {
+			return 0
+		},
+		"set_repl": func(L *lua.LState) int {
+			return 0
+		},
+		"replicate_commands": func(L *lua.LState) int {
+			L.Push(lua.LTrue)
+			return 1
+		},
+	})
+	if ks != nil {
+		mod.RawSetString("call", L.NewFunction(luaCall(ks, false)))
+		mod.RawSetString("pcall", L.NewFunction(luaCall(ks, true)))
+	}
+	if fns != nil {
+		mod.RawSetString("register_function", L.NewFunction(registerFunction(fns)))
+	}
+	for i, level := range []string{"LOG_DEBUG", "LOG_VERBOSE", "LOG_NOTICE", "LOG_WARNING"} {
+		mod.RawSetString(level, lua.LNumber(i))
+	}
+	L.SetGlobal("redis", mod)
+
+	return L
+}
+
+const readOnlyRegistryKey = "redismock.readonly"
+
+func setReadOnly(L *lua.LState, readOnly bool) {
+	L.SetField(L.Get(lua.RegistryIndex), readOnlyRegistryKey, lua.LBool(readOnly))
+}
+
+// luaCall implements redis.call and redis.pcall, the keyspace lock is held by the running script.
+func luaCall(ks *Keyspace, protected bool) lua.LGFunction {
+	return func(L *lua.LState) int {
+		args := make([]string, L.GetTop())
+		for i := range args {
+			switch v := L.Get(i + 1).(type) {
+			case lua.LString:
+				args[i] = string(v)
+			case lua.LNumber:
+				// the shortest representation, like redis-server 7
+				args[i] = strconv.FormatFloat(float64(v), 'g', -1, 64)
+			default:
+				L.Error(replyTable(L, "err", "ERR Lua redis lib command arguments must be strings or integers"), 1)
+				return 0
+			}
+		}
+		if len(args) == 0 {
+			L.Error(replyTable(L, "err", "ERR Please specify at least one argument for this redis lib call"), 1)
+			return 0
+		}
+
+		var val interface{}
+		var err error
+		if lua.LVAsBool(L.GetField(L.Get(lua.RegistryIndex), readOnlyRegistryKey)) && isWriteCommand(args[0]) {
+			err = errReadOnlyScript
+		} else {
+			val, err = ks.do(args)
+		}
+
+		if err != nil {
+			reply := replyTable(L, "err", err.Error())
+			if !protected {
+				L.Error(reply, 1)
+				return 0
+			}
+			L.Push(reply)
+			return 1
+		}
+		L.Push(toLua(L, val))
+		return 1
+	}
+}
+
+func registerFunction(fns map[string]luaFunction) lua.LGFunction {
+	return func(L *lua.LState) int {
+		var name string
+		var fn luaFunction
+
+		if tbl, ok := L.Get(1).(*lua.LTable); ok {
+			name = lua.LVAsString(tbl.RawGetString("function_name"))
+			fn.callback, _ = tbl.RawGetString("callback").(*lua.LFunction)
+			if flags, ok := tbl.RawGetString("flags").(*lua.LTable); ok {
+				flags.ForEach(func(_, flag lua.LValue) {
+					if flag.String() == "no-writes" {
+						fn.noWrites = true
+					}
+				})
+			}
+		} else {
+			name = L.CheckString(1)
+			fn.callback = L.CheckFunction(2)
+		}
+
+		if name == "" || fn.callback == nil {
+			L.RaiseError("wrong arguments to redis.register_function")
+			return 0
+		}
+		if _, ok := fns[name]; ok {
+			L.RaiseError("Function %s already exists", name)
+			return 0
+		}
+		fns[name] = fn
+		return 0
+	}
+}
+
+// runScript calls the function on the stack and converts its return value.
+func runScript(L *lua.LState, nargs int) (interface{}, error) {
+	if err := L.PCall(nargs, 1, nil); err != nil {
+		if apiErr, ok := err.(*lua.ApiError); ok {
+			if tbl, ok := apiErr.Object.(*lua.LTable); ok {
+				if msg, ok := tbl.RawGetString("err").(lua.LString); ok {
+					return nil, newRedisError(string(msg))
+				}
+			}
+		}
+		return nil, newRedisError("ERR " + luaErrorMessage(err))
+	}
+	return fromLua(L.Get(-1))
+}
+
+func luaErrorMessage(err error) string {
+	if apiErr, ok := err.(*lua.ApiError); ok && apiErr.Object != nil {
+		return apiErr.Object.String()
+	}
+	return err.Error()
+}
+
+// toLua converts a keyspace reply following the Redis to Lua conversion rules.
+func toLua(L *lua.LState, val interface{}) lua.LValue {
+	switch v := val.(type) {
+	case nil:
+		return lua.LFalse
+	case int64:
+		return lua.LNumber(v)
+	case string:
+		return lua.LString(v)
+	case statusReply:
+		return replyTable(L, "ok", string(v))
+	case []interface{}:
+		tbl := L.NewTable()
+		for _, item := range v {
+			tbl.Append(toLua(L, item))
+		}
+		return tbl
+	default:
+		return lua.LString(fmt.Sprint(v))
+	}
+}
+
+// fromLua converts a script return value following the Lua to Redis conversion rules.
+func fromLua(lv lua.LValue) (interface{}, error) {
+	switch v := lv.(type) {
+	case lua.LNumber:
+		return int64(math.Trunc(float64(v))), nil
+	case lua.LString:
+		return string(v), nil
+	case lua.LBool:
+		if v {
+			return int64(1), nil
+		}
+		return nil, nil
+	case *lua.LTable:
+		if msg, ok := v.RawGetString("err").(lua.LString); ok {
+			return nil, newRedisError(string(msg))
+		}
+		if msg, ok := v.RawGetString("ok").(lua.LString); ok {
+			return statusReply(msg), nil
+		}
+		vals := make([]interface{}, 0, v.Len())
+		for i := 1; ; i++ {
+			item := v.RawGetInt(i)
+			if item == lua.LNil {
+				break
+			}
+			val, err := fromLua(item)
+			if err != nil {
+				// errors nested in an array are returned as an element
+				val = err
+			}
+			vals = append(vals, val)
+		}
+		return vals, nil
+	default:
+		return nil, nil
+	}
+}
+
+func replyTable(L *lua.LState, field, msg string) *lua.LTable {
+	tbl := L.NewTable()
+	tbl.RawSetString(field, lua.LString(msg))
+	return tbl
+}
+
+func stringsTable(L *lua.LState, ss []string) *lua.LTable {
+	tbl := L.CreateTable(len(ss), 0)
+	for _, s := range ss {
+		tbl.Append(lua.LString(s))
+	}
+	return tbl
+}
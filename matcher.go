@@ -0,0 +1,107 @@
+package redismock
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Matcher matches the commands of an expectation, in place of its name and arguments.
+// Placement and context checks still apply, see WithMatcher and MatchWith.
+type Matcher interface {
+	// Match returns why cmd does not match, or nil.
+	Match(ctx context.Context, cmd redis.Cmder) error
+
+	// String describes the commands matched, it shows in the errors.
+	String() string
+}
+
+// MatchWith matches the expectations with mt, see Matcher.
+func MatchWith(mt Matcher) Option {
+	return func(scope *mock) {
+		scope.expectMatcher = mt
+	}
+}
+
+// MatcherFunc returns a Matcher calling fn, described by desc.
+func MatcherFunc(desc string, fn func(ctx context.Context, cmd redis.Cmder) error) Matcher {
+	return funcMatcher{desc: desc, fn: fn}
+}
+
+// RegexpMatcher matches commands with the name args[0], and arguments matching the regular
+// expressions of args[1:] like an expectation of the Regexp scope.
+func RegexpMatcher(args ...interface{}) Matcher {
+	return newArgsMatcher(args, true, nil)
+}
+
+// CustomMatcher matches commands with the name args[0] and arguments fn accepts,
+// like an expectation of the CustomMatch scope.
+func CustomMatcher(fn CustomMatch, args ...interface{}) Matcher {
+	return newArgsMatcher(args, false, fn)
+}
+
+type funcMatcher struct {
+	desc string
+	fn   func(ctx context.Context, cmd redis.Cmder) error
+}
+
+func (f funcMatcher) Match(ctx context.Context, cmd redis.Cmder) error {
+	return f.fn(ctx, cmd)
+}
+
+func (f funcMatcher) String() string {
+	return f.desc
+}
+
+// argsMatcher matches the name and the arguments of commands, the way expectations do.
+type argsMatcher struct {
+	name   string
+	args   []interface{}
+	regexp bool
+	custom CustomMatch
+}
+
+func newArgsMatcher(args []interface{}, isRegexp bool, fn CustomMatch) argsMatcher {
+	var name string
+	if len(args) > 0 {
+		name = strings.ToLower(fmt.Sprint(args[0]))
+	}
+	return argsMatcher{name: name, args: args, regexp: isRegexp, custom: fn}
+}
+
+func (am argsMatcher) Match(_ context.Context, cmd redis.Cmder) error {
+	return matchArgs(am.name, am.args, am.regexp, am.custom, cmd)
+}
+
+func (am argsMatcher) String() string {
+	switch {
+	case am.custom != nil:
+		return fmt.Sprintf("custom match of %+v", am.args)
+	case am.regexp:
+		return fmt.Sprintf("regexp match of %+v", am.args)
+	}
+	return fmt.Sprintf("%+v", am.args)
+}
+
+// matchCmdType checks that the reply of expect can be written into cmd. A Matcher does not
+// check the name of the command, it may accept a command replying another type of value.
+func matchCmdType(expect expectation, cmd redis.Cmder) error {
+	if _, ok := cmd.(*wireCmd); ok {
+		return nil
+	}
+	if c := expect.command(); c != nil && reflect.TypeOf(c) != reflect.TypeOf(cmd) {
+		return fmt.Errorf("cmd type not match, expectation '%T', but call to cmd '%+v' is '%T'", c, cmd.Args(), cmd)
+	}
+	return nil
+}
+
+// matchWith matches cmd with mt, its error tells which matcher refused cmd.
+func matchWith(ctx context.Context, mt Matcher, cmd redis.Cmder) error {
+	if err := mt.Match(ctx, cmd); err != nil {
+		return fmt.Errorf("matcher '%s' does not match call to cmd '%+v': %w", mt, cmd.Args(), err)
+	}
+	return nil
+}
@@ -6,7 +6,7 @@ import (
 	"net"
 	"reflect"
 	"regexp"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -22,11 +22,56 @@ type mock struct {
 	expected []expectation
 
 	strictOrder bool
+	crossSlot   bool
 
-	expectRegexp bool
-	expectCustom CustomMatch
+	expectRegexp  bool
+	expectCustom  CustomMatch
+	expectPlace   placement
+	expectTimes   int
+	expectName    string
+	expectMatcher Matcher
 
 	clientType redisClientType
+
+	watch     *watchState
+	watches   *watchStates
+	functions *functionState
+
+	scripts *scriptEngine
+
+	conns *connScopes
+
+	handshake *handshakeState
+
+	topology *clusterTopology
+
+	calls *callLog
+}
+
+// watchState tracks the keys of the current WATCH of a connection. The commands of the client
+// share the state of the mock, shared by all clones of a mock, each client.Conn() has its own.
+type watchState struct {
+	mu       sync.Mutex
+	keys     map[string]struct{}
+	modified bool
+}
+
+// watchStates are the watch states of all connections, ModifyWatchedKeys modifies the keys
+// watched by any of them.
+type watchStates struct {
+	mu     sync.Mutex
+	states map[*watchState]struct{}
+}
+
+// watchKey holds the watch state of the connection a command is sent on.
+type watchKey struct{}
+
+// functionState is the registry of the loaded function libraries, shared by all clones of a mock
+// and by its script engine. Matched FUNCTION commands, PreloadFunctions and the FUNCTION commands
+// executed with ExecuteScripts all change it.
+type functionState struct {
+	mu        sync.Mutex
+	libraries map[string]*luaLibrary
 }
 
 type redisClientType int
@@ -41,15 +86,34 @@ func NewClientMock() (*redis.Client, ClientMock) {
 	return m.client.(*redis.Client), m
 }
 
+// NewClientMockWithOptions creates a client mock whose connections are initialised like
+// the connections of a real client: HELLO, AUTH, SELECT and CLIENT SETNAME built from opt,
+// then opt.OnConnect, are matched against the expectations before the first command.
+// The Addr, Dialer and retry options of opt are ignored.
+func NewClientMockWithOptions(opt *redis.Options) (*redis.Client, ClientMock) {
+	m := newMockWithOptions(redisClient, opt)
+	return m.client.(*redis.Client), m
+}
+
 func NewClusterMock() (*redis.ClusterClient, ClusterClientMock) {
 	m := newMock(redisCluster)
 	return m.client.(*redis.ClusterClient), m
 }
 
 func newMock(typ redisClientType) *mock {
+	return newMockWithOptions(typ, nil)
+}
+
+// newMockWithOptions creates a mock whose client is built from clientOpt, see NewClientMockWithOptions.
+// clientOpt is only used by a client mock, nil creates a client without connection init.
+func newMockWithOptions(typ redisClientType, clientOpt *redis.Options) *mock {
 	m := &mock{
 		ctx:        context.Background(),
 		clientType: typ,
+		watches:    &watchStates{},
+		functions:  &functionState{},
+		conns:      &connScopes{},
+		calls:      &callLog{},
 	}
 
 	// MaxRetries/MaxRedirects set -2, avoid executing commands on the redis server
@@ -57,9 +121,20 @@ func newMock(typ redisClientType) *mock {
 	case redisClient:
 		opt := &redis.Options{MaxRetries: -2}
 		factory := redis.NewClient(opt)
-		client := redis.NewClient(opt)
+
+		// client.Conn() does not run the hooks, its commands are sent once (MaxRetries -1)
+		// on a dialed connection. Idle connections are closed, every Conn() dials a new one.
+		var o redis.Options
+		hook := redisClientHook{fn: m.process, pipeline: m.processPipeline}
+		if clientOpt != nil {
+			o = *clientOpt
+			m.handshake = &handshakeState{}
+			hook.connect = m.connect
+		}
+		o.MaxRetries, o.MaxIdleConns, o.Dialer = -1, -1, m.dial
+		client := redis.NewClient(&o)
 		factory.AddHook(nilHook{})
-		client.AddHook(redisClientHook{fn: m.process})
+		client.AddHook(hook)
 
 		m.factory = factory
 		m.client = client
@@ -68,12 +143,13 @@ func newMock(typ redisClientType) *mock {
 		factory := redis.NewClusterClient(opt)
 		clusterClient := redis.NewClusterClient(opt)
 		factory.AddHook(nilHook{})
-		clusterClient.AddHook(redisClientHook{fn: m.process})
+		clusterClient.AddHook(redisClientHook{fn: m.process, pipeline: m.processPipeline})
 
 		m.factory = factory
 		m.client = clusterClient
 	}
 	m.strictOrder = true
+	m.watch = m.watches.add()
 
 	return m
 }
@@ -82,7 +158,11 @@ func newMock(typ redisClientType) *mock {
 
 type redisClientHook struct {
 	returnErr error
-	fn        func(cmd redis.Cmder) error
+	fn        func(ctx context.Context, cmd redis.Cmder) error
+	pipeline  func(ctx context.Context, cmds []redis.Cmder) error
+
+	// connect initialises the connection before a command is processed, see NewClientMockWithOptions
+	connect func(ctx context.Context) error
 }
 
 func (redisClientHook) DialHook(hook redis.DialHook) redis.DialHook {
@@ -91,7 +171,13 @@ func (redisClientHook) DialHook(hook redis.DialHook) redis.DialHook {
 
 func (h redisClientHook) ProcessHook(_ redis.ProcessHook) redis.ProcessHook {
 	return func(ctx context.Context, cmd redis.Cmder) error {
-		err := h.fn(cmd)
+		if h.connect != nil {
+			if err := h.connect(ctx); err != nil {
+				cmd.SetErr(err)
+				return err
+			}
+		}
+		err := h.fn(ctx, cmd)
 		if h.returnErr != nil && (err == nil || cmd.Err() == nil) {
 			err = h.returnErr
 		}
@@ -101,16 +187,17 @@ func (h redisClientHook) ProcessHook(_ redis.ProcessHook) redis.ProcessHook {
 
 func (h redisClientHook) ProcessPipelineHook(_ redis.ProcessPipelineHook) redis.ProcessPipelineHook {
 	return func(ctx context.Context, cmds []redis.Cmder) error {
-		for _, cmd := range cmds {
-			err := h.fn(cmd)
-			if h.returnErr != nil && (err == nil || cmd.Err() == nil) {
-				err = h.returnErr
-			}
-			if err != nil {
+		if h.connect != nil {
+			if err := h.connect(ctx); err != nil {
+				setCmdsErr(cmds, err)
 				return err
 			}
 		}
-		return nil
+		err := h.pipeline(ctx, cmds)
+		if h.returnErr != nil && err == nil {
+			err = h.returnErr
+		}
+		return err
 	}
 }
 
@@ -136,9 +223,97 @@ func (h nilHook) ProcessPipelineHook(_ redis.ProcessPipelineHook) redis.ProcessP
 
 //----------------------------------
 
-func (m *mock) process(cmd redis.Cmder) (err error) {
+func (m *mock) process(ctx context.Context, cmd redis.Cmder) (err error) {
+	if m.crossSlot {
+		if err = checkCrossSlot(cmd); err != nil {
+			cmd.SetErr(err)
+			return err
+		}
+	}
+
+	if m.scripts != nil && m.scripts.handles(cmd) {
+		return m.scripts.process(cmd)
+	}
+
+	expect, err := m.find(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	expect.trigger()
+
+	if b, ok := expect.(blockingExpectation); ok && b.blocking() {
+		if err = m.park(ctx, expect, b, cmd); err != nil {
+			expect.unlock()
+			cmd.SetErr(err)
+			return err
+		}
+		b.arrived()
+	}
+
+	if g := expect.gated(); g != nil {
+		if err = m.pass(ctx, expect, g); err != nil {
+			expect.unlock()
+			cmd.SetErr(err)
+			return err
+		}
+	}
+
+	defer expect.unlock()
+
+	if err = m.reply(expect, cmd); err == nil {
+		m.trackWatch(ctx, cmd)
+	}
+	return err
+}
+
+// park waits until the test delivers the reply of a blocking command, the timeout
+// of the command expires (redis.Nil) or ctx is done. The expectation is consumed
+// and unlocked while waiting, it is locked again when park returns.
+func (m *mock) park(ctx context.Context, expect expectation, b blockingExpectation, cmd redis.Cmder) error {
+	ready, timeout := b.arrival(cmd)
+	expect.unlock()
+	defer expect.lock()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case <-ready:
+		return nil
+	case <-expired:
+		return redis.Nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pass holds the caller at the gate of the expectation until the test releases it or ctx is done.
+// Like park, the expectation is consumed and unlocked while waiting.
+func (m *mock) pass(ctx context.Context, expect expectation, g *Gate) error {
+	expect.unlock()
+	defer expect.lock()
+
+	g.arrive()
+	select {
+	case <-g.open:
+		return nil
+	case <-g.until:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// find returns the locked expectation matching cmd.
+// If there is none, the error is also written into cmd.
+func (m *mock) find(ctx context.Context, cmd redis.Cmder) (expectation, error) {
+	m.calls.record(ctx, cmd)
+
 	var miss int
-	var expect expectation = nil
 
 	for _, e := range m.expected {
 		e.lock()
@@ -150,37 +325,30 @@ func (m *mock) process(cmd redis.Cmder) (err error) {
 			continue
 		}
 
-		err = m.match(e, cmd)
+		err := m.match(ctx, e, cmd)
 
 		// matched
 		if err == nil {
-			expect = e
-			break
+			return e, nil
 		}
 
 		// strict order of command execution
 		if m.strictOrder {
 			e.unlock()
+			err = &UnexpectedCallError{Cmd: cmd, Err: err}
 			cmd.SetErr(err)
-			return err
+			return nil, err
 		}
 		e.unlock()
 	}
 
-	if expect == nil {
-		msg := "call to cmd '%+v' was not expected"
-		if miss == len(m.expected) {
-			msg = "all expectations were already fulfilled, " + msg
-		}
-		err = fmt.Errorf(msg, cmd.Args())
-		cmd.SetErr(err)
-		return err
-	}
-
-	defer expect.unlock()
-
-	expect.trigger()
+	err := &UnexpectedCallError{Cmd: cmd, Fulfilled: miss == len(m.expected)}
+	cmd.SetErr(err)
+	return nil, err
+}
 
+// reply writes the result of a matched expectation into cmd.
+func (m *mock) reply(expect expectation, cmd redis.Cmder) (err error) {
 	// write error
 	if err = expect.error(); err != nil {
 		cmd.SetErr(err)
@@ -195,6 +363,10 @@ func (m *mock) process(cmd redis.Cmder) (err error) {
 	}
 
 	// if you do not set error or redis.Nil, must set val
+	if !expect.isSetVal() && m.answerSlot(cmd) {
+		cmd.SetErr(nil)
+		return nil
+	}
 	if !expect.isSetVal() {
 		err = fmt.Errorf("cmd(%s), return value is required", expect.name())
 		cmd.SetErr(err)
@@ -203,56 +375,517 @@ func (m *mock) process(cmd redis.Cmder) (err error) {
 
 	cmd.SetErr(nil)
 	expect.inflow(cmd)
+	m.reorderReply(expect, cmd)
+	m.trackFunctions(cmd)
 
 	return nil
 }
 
-func (m *mock) match(expect expectation, cmd redis.Cmder) error {
-	expectArgs := expect.args()
+// reorderReply moves the values of an HMGET reply to the position of their field in the call,
+// the fields may be in another order than the fields of the expectation.
+func (m *mock) reorderReply(expect expectation, cmd redis.Cmder) {
+	c, ok := cmd.(*redis.SliceCmd)
+	if !ok || cmd.Name() != "hmget" || expect.custom() != nil || expect.matcher() != nil || len(expect.args()) != len(cmd.Args()) {
+		return
+	}
+	expectFields, cmdFields := expect.args()[2:], cmd.Args()[2:]
+	vals := c.Val()
+	if len(vals) != len(cmdFields) {
+		return
+	}
+
+	reordered := make([]interface{}, len(vals))
+	used := make([]bool, len(expectFields))
+	for i, field := range cmdFields {
+		for j := range expectFields {
+			if !used[j] && compare(expect.regexp(), expectFields[j], field) == nil {
+				used[j] = true
+				reordered[i] = vals[j]
+				break
+			}
+		}
+	}
+	c.SetVal(reordered)
+}
+
+// processPipeline handles the commands sent by a single Pipeline/TxPipeline Exec.
+func (m *mock) processPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	if isTxPipeline(cmds) {
+		return m.processTxPipeline(ctx, cmds)
+	}
+
+	if e, err := m.findPipeline(ctx, cmds); err != nil {
+		m.calls.record(ctx, cmds...)
+		setCmdsErr(cmds, err)
+		return err
+	} else if e != nil {
+		m.calls.record(ctx, cmds...)
+		return m.replyPipeline(ctx, e, cmds)
+	}
+
+	// like redis-server, a failed command does not stop the rest of the pipeline,
+	// every command gets its own reply and Exec returns the first error.
+	var firstErr error
+	for _, cmd := range cmds {
+		if err := m.process(ctx, cmd); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// findPipeline looks for an ExpectPipeline group matching cmds.
+// Neither value is set if the pipeline should be matched command by command.
+func (m *mock) findPipeline(ctx context.Context, cmds []redis.Cmder) (*ExpectedPipeline, error) {
+	for _, e := range m.expected {
+		e.lock()
+		if !e.usable() {
+			e.unlock()
+			continue
+		}
+		pipe, ok := e.(*ExpectedPipeline)
+		if !ok {
+			e.unlock()
+			if m.strictOrder {
+				return nil, nil
+			}
+			continue
+		}
+
+		err := m.matchPipeline(ctx, pipe, cmds)
+		if err == nil {
+			// consumed before it is unlocked, a concurrent Exec can not match it too
+			pipe.trigger()
+		}
+		pipe.unlock()
+
+		if err == nil {
+			return pipe, nil
+		}
+		if m.strictOrder {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// matchPipeline matches cmds against the expectations of the group and
+// remembers which expectation answers which command.
+func (m *mock) matchPipeline(ctx context.Context, pipe *ExpectedPipeline, cmds []redis.Cmder) error {
+	if err := matchContext(ctx, pipe, cmds[0]); err != nil {
+		return err
+	}
+	expected := pipe.group.expected
+	if len(expected) != len(cmds) {
+		return fmt.Errorf("pipeline not match, expectation %d cmds '%+v', but pipeline has %d cmds '%+v'",
+			len(expected), pipe.args(), len(cmds), cmdsArgs(cmds))
+	}
+
+	unordered := pipe.unordered || !pipe.group.strictOrder
+	matched := make([]expectation, len(cmds))
+	for i, cmd := range cmds {
+		if !unordered {
+			if err := m.match(ctx, expected[i], cmd); err != nil {
+				return fmt.Errorf("pipeline cmd #%d: %w", i, err)
+			}
+			matched[i] = expected[i]
+			continue
+		}
+
+		for _, e := range expected {
+			if containsExpectation(matched, e) {
+				continue
+			}
+			if err := m.match(ctx, e, cmd); err == nil {
+				matched[i] = e
+				break
+			}
+		}
+		if matched[i] == nil {
+			return fmt.Errorf("pipeline cmd #%d '%+v' was not expected in the pipeline", i, cmd.Args())
+		}
+	}
+
+	pipe.matched = matched
+	return nil
+}
+
+func (m *mock) replyPipeline(ctx context.Context, pipe *ExpectedPipeline, cmds []redis.Cmder) error {
+	pipe.lock()
+	defer pipe.unlock()
+
+	if err := pipe.error(); err != nil {
+		setCmdsErr(cmds, err)
+		return err
+	}
+
+	var firstErr error
+	for i, cmd := range cmds {
+		e := pipe.matched[i]
+		e.lock()
+		e.trigger()
+		err := m.reply(e, cmd)
+		e.unlock()
+
+		if err == nil {
+			m.trackWatch(ctx, cmd)
+		} else if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// processTxPipeline handles MULTI, the queued commands and EXEC.
+//
+// Queued commands only consume their expectations, redis-server answers them with QUEUED.
+// Their replies are written when EXEC succeeds. A command rejected while queueing
+// (unexpected, or SetQueueErr) makes EXEC fail with EXECABORT and discards the transaction,
+// while an error set with SetErr only fails that command inside the EXEC reply.
+func (m *mock) processTxPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	watch := m.watchOf(ctx)
+	modified := watch.isModified()
+	defer watch.reset()
+
+	multi, queued, exec := cmds[0], cmds[1:len(cmds)-1], cmds[len(cmds)-1]
+	if err := m.process(ctx, multi); err != nil {
+		setCmdsErr(cmds, err)
+		return err
+	}
+
+	var aborted bool
+	var unexpectedErr error
+	replies := make([]expectation, len(queued))
+	for i, cmd := range queued {
+		if m.crossSlot {
+			if err := checkCrossSlot(cmd); err != nil {
+				cmd.SetErr(err)
+				aborted = true
+				continue
+			}
+		}
+
+		e, err := m.find(ctx, cmd)
+		if err != nil {
+			if unexpectedErr == nil {
+				unexpectedErr = err
+			}
+			continue
+		}
+		e.trigger()
+		if e.queueError() != nil {
+			aborted = true
+		}
+		e.unlock()
+		replies[i] = e
+	}
+
+	execErr := m.process(ctx, exec)
+	if aborted || unexpectedErr != nil {
+		abortErr := newRedisError("EXECABORT Transaction discarded because of previous errors.")
+		for _, cmd := range cmds {
+			// keep the mismatch message of unexpected commands
+			if cmd.Err() == nil || cmd == exec {
+				cmd.SetErr(abortErr)
+			}
+		}
+		if unexpectedErr != nil {
+			return unexpectedErr
+		}
+		return abortErr
+	}
+
+	// EXEC returned a null reply, the transaction was aborted.
+	if modified && execErr == nil {
+		execErr = redis.TxFailedErr
+	}
+	if execErr != nil {
+		setCmdsErr(cmds, execErr)
+		return execErr
+	}
+
+	var firstErr error
+	for i, cmd := range queued {
+		e := replies[i]
+		e.lock()
+		err := m.reply(e, cmd)
+		e.unlock()
+
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// trackWatch records the keys watched by a successful WATCH command.
+func (m *mock) trackWatch(ctx context.Context, cmd redis.Cmder) {
+	switch cmd.Name() {
+	case "watch":
+		args := cmd.Args()
+		keys := make([]string, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			keys = append(keys, fmt.Sprint(arg))
+		}
+		m.watchOf(ctx).add(keys...)
+	case "unwatch":
+		m.watchOf(ctx).reset()
+	}
+}
+
+// watchOf returns the watch state of the connection of ctx, the state of the client commands
+// if ctx does not come from a client.Conn().
+func (m *mock) watchOf(ctx context.Context) *watchState {
+	if w, ok := ctx.Value(watchKey{}).(*watchState); ok {
+		return w
+	}
+	return m.watch
+}
+
+// trackFunctions records the libraries changed by a successful FUNCTION command.
+func (m *mock) trackFunctions(cmd redis.Cmder) {
+	if cmd.Name() != "function" {
+		return
+	}
+	args := cmd.Args()
+	switch subCommand(cmd) {
+	case "load":
+		// code that is not a valid library only matters to the expectation
+		_, _ = m.functions.load(fmt.Sprint(args[len(args)-1]), true)
+	case "delete":
+		if len(args) > 2 {
+			m.functions.remove(fmt.Sprint(args[2]))
+		}
+	case "flush":
+		m.functions.flush()
+	}
+}
+
+// load registers the library of code and returns its name. Like FUNCTION LOAD, a library
+// of the same name is only replaced if replace is set, and functions are unique across libraries.
+func (f *functionState) load(code string, replace bool) (string, error) {
+	lib, err := parseLibrary(code)
+	if err != nil {
+		return "", err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.libraries[lib.name]; ok && !replace {
+		return "", newRedisError("ERR Library '" + lib.name + "' already exists")
+	}
+	for fn := range lib.functions {
+		for _, other := range f.libraries {
+			if _, ok := other.functions[fn]; ok && other.name != lib.name {
+				return "", newRedisError("ERR Function " + fn + " already exists")
+			}
+		}
+	}
+	if f.libraries == nil {
+		f.libraries = make(map[string]*luaLibrary)
+	}
+	f.libraries[lib.name] = lib
+	return lib.name, nil
+}
+
+// remove deletes the library name, it reports whether the library was loaded.
+func (f *functionState) remove(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.libraries[name]
+	delete(f.libraries, name)
+	return ok
+}
+
+func (f *functionState) flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.libraries = nil
+}
+
+// library returns the library registering function, nil if it is not loaded.
+func (f *functionState) library(function string) *luaLibrary {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, lib := range f.libraries {
+		if _, ok := lib.functions[function]; ok {
+			return lib
+		}
+	}
+	return nil
+}
+
+func (f *functionState) has(function string) bool {
+	return f.library(function) != nil
+}
+
+func (s *watchStates) add() *watchState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.states == nil {
+		s.states = make(map[*watchState]struct{})
+	}
+	w := &watchState{}
+	s.states[w] = struct{}{}
+	return w
+}
+
+func (s *watchStates) remove(w *watchState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.states, w)
+}
+
+func (s *watchStates) modify(keys ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for w := range s.states {
+		w.modify(keys...)
+	}
+}
+
+func (w *watchState) add(keys ...string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.keys == nil {
+		w.keys = make(map[string]struct{})
+	}
+	for _, key := range keys {
+		w.keys[key] = struct{}{}
+	}
+}
+
+func (w *watchState) modify(keys ...string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, key := range keys {
+		if _, ok := w.keys[key]; ok {
+			w.modified = true
+		}
+	}
+}
+
+func (w *watchState) isModified() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.modified
+}
+
+func (w *watchState) reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.keys = nil
+	w.modified = false
+}
+
+// newRedisError returns an error of the same type as the errors read from redis-server,
+// so that go-redis handles it like a server reply.
+func newRedisError(msg string) error {
+	v := reflect.New(reflect.TypeOf(redis.TxFailedErr)).Elem()
+	v.SetString(msg)
+	return v.Interface().(error)
+}
+
+func isTxPipeline(cmds []redis.Cmder) bool {
+	return len(cmds) >= 2 && cmds[0].Name() == "multi" && cmds[len(cmds)-1].Name() == "exec"
+}
+
+func setCmdsErr(cmds []redis.Cmder, err error) {
+	for _, cmd := range cmds {
+		cmd.SetErr(err)
+	}
+}
+
+func cmdsArgs(cmds []redis.Cmder) [][]interface{} {
+	args := make([][]interface{}, len(cmds))
+	for i, cmd := range cmds {
+		args[i] = cmd.Args()
+	}
+	return args
+}
+
+func containsExpectation(list []expectation, e expectation) bool {
+	for _, v := range list {
+		if v == e {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *mock) match(ctx context.Context, expect expectation, cmd redis.Cmder) error {
+	if _, ok := expect.(*ExpectedPipeline); ok {
+		return fmt.Errorf("expectation is a pipeline '%+v', but call to cmd '%+v'", expect.args(), cmd.Args())
+	}
+
+	if err := m.matchPlacement(ctx, expect, cmd); err != nil {
+		return err
+	}
+
+	var err error
+	if mt := expect.matcher(); mt != nil {
+		if err = matchWith(ctx, mt, cmd); err == nil {
+			err = matchCmdType(expect, cmd)
+		}
+	} else if script, ok := expect.(*ExpectedScript); ok {
+		err = m.matchScript(script, cmd)
+	} else {
+		err = matchArgs(expect.name(), expect.args(), expect.regexp(), expect.custom(), cmd)
+	}
+	if err != nil {
+		return err
+	}
+
+	return matchContext(ctx, expect, cmd)
+}
+
+// matchArgs compares cmd with the name and args of an expected command, with regular expressions
+// if isRegexp is set or with fn if it is not nil.
+func matchArgs(name string, expectArgs []interface{}, isRegexp bool, fn CustomMatch, cmd redis.Cmder) error {
 	cmdArgs := cmd.Args()
 
+	// commands read from a connection only carry strings
+	if _, ok := cmd.(*wireCmd); ok {
+		ss, err := wireArgs(expectArgs)
+		if err != nil {
+			return err
+		}
+		expectArgs = make([]interface{}, len(ss))
+		for i, s := range ss {
+			expectArgs[i] = s
+		}
+	}
+
 	if len(expectArgs) != len(cmdArgs) {
 		return fmt.Errorf("parameters do not match, expectation '%+v', but call to cmd '%+v'", expectArgs, cmdArgs)
 	}
 
-	if expect.name() != cmd.Name() {
-		return fmt.Errorf("command not match, expectation '%s', but call to cmd '%s'", expect.name(), cmd.Name())
+	if name != cmd.Name() {
+		return fmt.Errorf("command not match, expectation '%s', but call to cmd '%s'", name, cmd.Name())
 	}
 
 	// custom func match
-	if fn := expect.custom(); fn != nil {
+	if fn != nil {
 		return fn(expectArgs, cmdArgs)
 	}
 
-	isMapArgs := m.mapArgs(cmd.Name(), &cmdArgs)
-	if isMapArgs {
-		m.mapArgs(expect.name(), &expectArgs)
+	spans := argSpans(cmd.Name(), cmdArgs)
+	if !reflect.DeepEqual(spans, argSpans(name, expectArgs)) {
+		spans = nil
 	}
 
 	for i := 0; i < len(expectArgs); i++ {
-		// is map?
-		if isMapArgs {
-			expectMapArgs, expectOK := expectArgs[i].(map[string]interface{})
-			cmdMapArgs, cmdOK := cmdArgs[i].(map[string]interface{})
-			if expectOK && cmdOK {
-				// there may be the same key
-				if len(expectMapArgs) != len(cmdMapArgs) {
-					return fmt.Errorf("wrong number of arguments, expectation regular: '%+v', but gave: '%+v'",
-						expectArgs, cmdArgs)
-				}
-				for expectKey, expectMapVal := range expectMapArgs {
-					cmdMapVal, ok := cmdMapArgs[expectKey]
-					if !ok {
-						return fmt.Errorf("missing command(%s) parameters: %s", expect.name(), expectKey)
-					}
-					if err := m.compare(expect.regexp(), expectMapVal, cmdMapVal); err != nil {
-						return err
-					}
-				}
-				continue
+		// the order of the arguments of the span does not matter
+		if len(spans) > 0 && spans[0].start == i {
+			span := spans[0]
+			if err := compareUnordered(isRegexp, span.size,
+				expectArgs[span.start:span.end], cmdArgs[span.start:span.end]); err != nil {
+				return err
 			}
+			spans, i = spans[1:], span.end-1
+			continue
 		}
-		if err := m.compare(expect.regexp(), expectArgs[i], cmdArgs[i]); err != nil {
+		if err := compare(isRegexp, expectArgs[i], cmdArgs[i]); err != nil {
 			return err
 		}
 	}
@@ -260,7 +893,35 @@ func (m *mock) match(expect expectation, cmd redis.Cmder) error {
 	return nil
 }
 
-func (m *mock) compare(isRegexp bool, expect, cmd interface{}) error {
+// matchScript accepts both EVALSHA and EVAL of the expected script, as sent by redis.Script.Run.
+func (m *mock) matchScript(script *ExpectedScript, cmd redis.Cmder) error {
+	script.noScriptNow = false
+
+	args := cmd.Args()
+	switch name := cmd.Name(); name {
+	case "evalsha", "evalsha_ro":
+		if len(args) > 1 && fmt.Sprint(args[1]) != script.hash {
+			return fmt.Errorf("script sha1 not match, expectation '%s' (sha1 of the script source), but call to cmd '%+v'",
+				script.hash, args)
+		}
+		expectArgs := append([]interface{}{name}, script.cmd.Args()[1:]...)
+		if err := matchArgs(name, expectArgs, script.regexp(), script.custom(), cmd); err != nil {
+			return err
+		}
+		script.noScriptNow = script.noScript && !script.missed
+		return nil
+	case "eval", "eval_ro":
+		expectArgs := append([]interface{}{name}, script.evalCmd.Args()[1:]...)
+		return matchArgs(name, expectArgs, script.regexp(), script.custom(), cmd)
+	default:
+		return fmt.Errorf("command not match, expectation script '%s', but call to cmd '%s'", script.hash, name)
+	}
+}
+
+// compare matches an argument of the expectation with the argument of the call. Arguments that
+// are not equal Go values still match if go-redis writes the same bytes for them, such as 1 and "1".
+// Regular expressions match the fmt.Sprint form of the argument, not its wire form.
+func compare(isRegexp bool, expect, cmd interface{}) error {
 	expr, ok := expect.(string)
 	if isRegexp && ok {
 		cmdValue := fmt.Sprint(cmd)
@@ -271,83 +932,88 @@ func (m *mock) compare(isRegexp bool, expect, cmd interface{}) error {
 		if !re.MatchString(cmdValue) {
 			return fmt.Errorf("args not match, expectation regular: '%s', but gave: '%s'", expr, cmdValue)
 		}
-	} else if !reflect.DeepEqual(expect, cmd) {
-		return fmt.Errorf("args not `DeepEqual`, expectation: '%+v', but gave: '%+v'", expect, cmd)
+		return nil
 	}
-	return nil
-}
 
-// using map in command leads to disorder, change the command parameter to map[string]interface{}
-// for example:
-//
-//	[mset key1 value1 key2 value2] => [mset map[string]interface{}{"key1": "value1", "key2": "value2"}]
-//
-// return bool, is it handled
-func (m *mock) mapArgs(cmd string, cmdArgs *[]interface{}) bool {
-	var cut int
-	cmd = strings.ToLower(cmd)
-	switch cmd {
-	case "mset", "msetnx":
-		// 1
-		cut = 1
-	case "hset", "hmset":
-		// 2
-		cut = 2
-	case "eval", "evalsha":
-		// more, i guess nobody uses it (eval/evalsha), miss
-		return false
-	default:
-		return false
+	if reflect.DeepEqual(expect, cmd) {
+		return nil
 	}
-
-	if n := len(*cmdArgs); n <= cut || (n > (cut+1) && (n-cut)%2 != 0) {
-		return false
+	cmdWire, cmdErr := wireArg(cmd)
+	expectWire, expectErr := wireArg(expect)
+	if expectErr != nil || cmdErr != nil {
+		return fmt.Errorf("args not `DeepEqual`, expectation: '%+v', but gave: '%+v'", expect, cmd)
+	}
+	if expectWire != cmdWire {
+		return fmt.Errorf("args not match, expectation: '%+v' (%T, wire %q), but gave: '%+v' (%T, wire %q)",
+			expect, expect, expectWire, cmd, cmd, cmdWire)
 	}
+	return nil
+}
 
-	mapArgs := make(map[string]interface{})
-	args := (*cmdArgs)[cut:]
-	switch v := args[0].(type) {
-	//[]string and map[string]interface{}, types will not appear normally
-	case []string:
-		if len(v)%2 != 0 {
-			return false
-		}
-		for i := 0; i < len(v); i += 2 {
-			mapArgs[v[i]] = v[i+1]
-		}
-	case map[string]interface{}:
-		if len(v) > 0 {
-			mapArgs = v
+// compareUnordered matches the groups of size arguments of the expectation with the groups of
+// the call, in any order.
+func compareUnordered(isRegexp bool, size int, expect, cmd []interface{}) error {
+	used := make([]bool, len(expect)/size)
+	for i := 0; i < len(cmd); i += size {
+		found := false
+		for j := range used {
+			if !used[j] && compareGroup(isRegexp, expect[j*size:(j+1)*size], cmd[i:i+size]) == nil {
+				used[j], found = true, true
+				break
+			}
 		}
-	default:
-		for i := 0; i < len(args); i += 2 {
-			mapArgs[fmt.Sprint(args[i])] = args[i+1]
+		if !found {
+			return fmt.Errorf("args not match in any order, expectation: '%+v', but gave: '%+v'", expect, cmd)
 		}
 	}
+	return nil
+}
 
-	if len(mapArgs) == 0 {
-		return false
+func compareGroup(isRegexp bool, expect, cmd []interface{}) error {
+	for i := range expect {
+		if err := compare(isRegexp, expect[i], cmd[i]); err != nil {
+			return err
+		}
 	}
-
-	newCmd := make([]interface{}, cut, cut+1)
-	copy(newCmd[:cut], (*cmdArgs)[:cut])
-	newCmd = append(newCmd, mapArgs)
-	*cmdArgs = newCmd
-	return true
+	return nil
 }
 
 func (m *mock) pushExpect(e expectation) {
+	if e.site() == "" {
+		e.setSite(callerSite())
+	}
 	if m.expectRegexp {
 		e.setRegexpMatch()
 	}
 	if m.expectCustom != nil {
 		e.setCustomMatch(m.expectCustom)
 	}
-	if m.parent != nil {
-		m.parent.pushExpect(e)
-		return
+	if m.expectTimes > 0 {
+		e.setTimes(m.expectTimes)
+	}
+	if m.expectName != "" {
+		e.setName(m.expectName)
+	}
+	if m.expectMatcher != nil {
+		e.setMatcher(m.expectMatcher)
 	}
-	m.expected = append(m.expected, e)
+	if p := m.expectPlace; p.addr != "" || p.role != "" {
+		p.err = m.place(p, e)
+		e.setPlacement(p)
+	} else if p.bySlot {
+		e.setPlacement(p)
+	}
+
+	// a scope carries the options of the scopes it derives from, the expectation goes to the mock
+	root := m
+	for root.parent != nil {
+		root = root.parent
+	}
+	root.expected = append(root.expected, e)
+}
+
+func (m *mock) Calls() []Call {
+	return m.calls.list()
 }
 
 func (m *mock) ClearExpect() {
@@ -356,54 +1022,230 @@ func (m *mock) ClearExpect() {
 		return
 	}
 	m.expected = nil
+	m.conns.reset()
 }
 
-func (m *mock) Regexp() *mock {
-	if m.parent != nil {
-		return m.parent.Regexp()
+// Option configures the expectations registered through a scope, see With.
+type Option func(scope *mock)
+
+// Regexp matches the arguments of the expectations with regular expressions.
+var Regexp Option = func(scope *mock) {
+	scope.expectRegexp = true
+}
+
+// Custom matches the arguments of the expectations with fn.
+func Custom(fn CustomMatch) Option {
+	return func(scope *mock) {
+		scope.expectCustom = fn
 	}
-	clone := *m
-	clone.parent = m
-	clone.expectRegexp = true
+}
 
-	return &clone
+// Times makes each expectation match n calls, instead of one. n must be at least 1.
+func Times(n int) Option {
+	if n < 1 {
+		panic(fmt.Sprintf("redismock: Times(%d), n must be at least 1", n))
+	}
+	return func(scope *mock) {
+		scope.expectTimes = n
+	}
 }
 
-func (m *mock) CustomMatch(fn CustomMatch) *mock {
-	if m.parent != nil {
-		return m.parent.CustomMatch(fn)
+// Named names the expectations, the name shows in the errors of ExpectationsWereMet.
+func Named(name string) Option {
+	return func(scope *mock) {
+		scope.expectName = name
+	}
+}
+
+// OnNode only matches the expectations with commands served by the node addr, see ClusterClientMock.Node.
+func OnNode(addr string) Option {
+	return placedOn(placement{addr: addr})
+}
+
+// OnMaster only matches the expectations with commands served by a master, see ClusterClientMock.Master.
+var OnMaster = placedOn(placement{role: roleMaster})
+
+// OnReplica only matches the expectations with commands served by a replica, see ClusterClientMock.Replica.
+var OnReplica = placedOn(placement{role: roleReplica})
+
+// InSlot only matches the expectations with commands whose keys hash to slot, see ForSlot.
+func InSlot(slot int) Option {
+	return placedOn(placement{bySlot: true, slot: slot})
+}
+
+// placedOn adds p to the placement of the scope.
+func placedOn(p placement) Option {
+	return func(scope *mock) {
+		place := &scope.expectPlace
+		if p.addr != "" {
+			place.addr = p.addr
+		}
+		if p.role != "" {
+			place.role = p.role
+		}
+		if p.bySlot {
+			place.bySlot, place.slot = true, p.slot
+		}
+	}
+}
+
+func (m *mock) With(opts ...Option) *mock {
+	scope := *m
+	scope.parent = m
+	for _, opt := range opts {
+		opt(&scope)
 	}
-	clone := *m
-	clone.parent = m
-	clone.expectCustom = fn
+	return &scope
+}
+
+func (m *mock) Regexp() *mock {
+	return m.With(Regexp)
+}
+
+func (m *mock) CustomMatch(fn CustomMatch) *mock {
+	return m.With(Custom(fn))
+}
+
+func (m *mock) Node(addr string) *mock {
+	return m.With(OnNode(addr))
+}
+
+func (m *mock) Master() *mock {
+	return m.With(OnMaster)
+}
+
+func (m *mock) Replica() *mock {
+	return m.With(OnReplica)
+}
+
+func (m *mock) ForSlot(slot int) *mock {
+	return m.With(InSlot(slot))
+}
+
+func (m *mock) ForHashTag(tag string) *mock {
+	return m.With(InSlot(Slot(tag)))
+}
+
+func (m *mock) MigrateSlot(slot int, addr string) {
+	m.mustTopology("MigrateSlot").migrate(slot, addr)
+}
+
+func (m *mock) StartSlotMigration(slot int, addr string) {
+	m.mustTopology("StartSlotMigration").startMigration(slot, addr)
+}
+
+func (m *mock) Redirects() (moved, ask int) {
+	return m.mustTopology("Redirects").redirects()
+}
+
+func (m *mock) NodeDown(addr string, failure NodeFailure) {
+	m.mustTopology("NodeDown").setDown(addr, failure)
+}
+
+func (m *mock) NodeUp(addr string) {
+	m.mustTopology("NodeUp").setDown(addr, 0)
+}
+
+func (m *mock) Failover(addr string) {
+	m.mustTopology("Failover").failover(addr)
+}
 
-	return &clone
+func (m *mock) Served(addr string) int {
+	return m.mustTopology("Served").servedCount(addr)
+}
+
+func (m *mock) ReplicaWrites() int {
+	return m.mustTopology("ReplicaWrites").replicaWriteCount()
+}
+
+func (m *mock) mustTopology(method string) *clusterTopology {
+	if m.topology == nil {
+		panic(fmt.Sprintf("redismock: %s requires a simulated cluster, see NewClusterMockWithTopology", method))
+	}
+	return m.topology
 }
 
 func (m *mock) ExpectationsWereMet() error {
 	if m.parent != nil {
-		return m.ExpectationsWereMet()
+		return m.parent.ExpectationsWereMet()
 	}
-	for _, e := range m.expected {
-		e.lock()
-		usable := e.usable()
-		e.unlock()
-
-		if usable {
-			return fmt.Errorf("there is a remaining expectation which was not matched: %+v", e.args())
+	var unmet []UnmetExpectation
+	for _, e := range m.allExpected() {
+		if u, ok := unmetExpectation(e); ok {
+			unmet = append(unmet, u)
 		}
 	}
+	if len(unmet) > 0 {
+		return &UnmetExpectationsError{Expectations: unmet}
+	}
 	return nil
 }
 
+func (m *mock) WaitForExpectations(ctx context.Context) error {
+	if m.parent != nil {
+		return m.parent.WaitForExpectations(ctx)
+	}
+	for {
+		err := m.ExpectationsWereMet()
+		if err == nil {
+			return nil
+		}
+		// expectations may be added while waiting, check them all again once these fired
+		for _, e := range m.allExpected() {
+			select {
+			case <-e.Done():
+			case <-ctx.Done():
+				if err = m.ExpectationsWereMet(); err != nil {
+					return fmt.Errorf("%w (%v)", err, ctx.Err())
+				}
+				return nil
+			}
+		}
+	}
+}
+
+func (m *mock) WaitForExpectationsWithTimeout(d time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return m.WaitForExpectations(ctx)
+}
+
+// allExpected returns the expectations of the mock and of its connection scopes.
+func (m *mock) allExpected() []expectation {
+	expected := append([]expectation(nil), m.expected...)
+	for _, scope := range m.conns.all() {
+		expected = append(expected, scope.allExpected()...)
+	}
+	return expected
+}
+
 func (m *mock) MatchExpectationsInOrder(b bool) {
 	if m.parent != nil {
-		m.MatchExpectationsInOrder(b)
+		m.parent.MatchExpectationsInOrder(b)
 		return
 	}
 	m.strictOrder = b
 }
 
+func (m *mock) CheckCrossSlot(b bool) {
+	if m.parent != nil {
+		m.parent.CheckCrossSlot(b)
+		return
+	}
+	m.crossSlot = b
+}
+
+func (m *mock) ExecuteScripts(ks *Keyspace) {
+	if m.parent != nil {
+		m.parent.ExecuteScripts(ks)
+		return
+	}
+	m.scripts = newScriptEngine(ks, m.functions)
+	for _, scope := range m.conns.all() {
+		scope.scripts = m.scripts
+	}
+}
+
 // -----------------------------------------------------
 
 func (m *mock) ExpectTxPipeline() {
@@ -413,8 +1255,34 @@ func (m *mock) ExpectTxPipeline() {
 	m.pushExpect(e)
 }
 
-func (m *mock) ExpectTxPipelineExec() *ExpectedSlice {
-	e := &ExpectedSlice{}
+func (m *mock) ExpectPipeline(fn func(p PipelineExpectations)) *ExpectedPipeline {
+	group := &mock{
+		ctx:           m.ctx,
+		factory:       m.factory,
+		clientType:    m.clientType,
+		strictOrder:   true,
+		expectRegexp:  m.expectRegexp,
+		expectCustom:  m.expectCustom,
+		expectPlace:   m.expectPlace,
+		expectName:    m.expectName,
+		expectMatcher: m.expectMatcher,
+		expectTimes:   m.expectTimes,
+		watch:         m.watch,
+		watches:       m.watches,
+		functions:     m.functions,
+		conns:         &connScopes{},
+		calls:         m.calls,
+	}
+	fn(group)
+
+	e := &ExpectedPipeline{group: group}
+	e.setVal = true
+	m.pushExpect(e)
+	return e
+}
+
+func (m *mock) ExpectTxPipelineExec() *ExpectedTxExec {
+	e := &ExpectedTxExec{}
 	e.cmd = redis.NewSliceCmd(m.ctx, "exec")
 	e.SetVal(nil)
 	m.pushExpect(e)
@@ -434,8 +1302,90 @@ func (m *mock) ExpectWatch(keys ...string) *ExpectedError {
 	return e
 }
 
+func (m *mock) ExpectUnwatch() *ExpectedStatus {
+	e := &ExpectedStatus{}
+	e.cmd = redis.NewStatusCmd(m.ctx, "unwatch")
+	e.SetVal("OK")
+	m.pushExpect(e)
+	return e
+}
+
+func (m *mock) ModifyWatchedKeys(keys ...string) {
+	m.watches.modify(keys...)
+}
+
 // ------------------------------------------------
 
+func (m *mock) ExpectConn() ConnMock {
+	if m.parent != nil {
+		return m.parent.ExpectConn()
+	}
+	scope := &mock{
+		ctx:         m.ctx,
+		factory:     m.factory,
+		client:      m.client,
+		clientType:  m.clientType,
+		strictOrder: m.strictOrder,
+		watch:       m.watch,
+		watches:     m.watches,
+		functions:   m.functions,
+		scripts:     m.scripts,
+		conns:       &connScopes{},
+		handshake:   m.handshake,
+		calls:       m.calls,
+	}
+	m.conns.add(scope)
+	return scope
+}
+
+// statefulCmds builds the commands of redis.StatefulCmdable, a pipeline queues them without
+// sending anything.
+func (m *mock) statefulCmds() redis.StatefulCmdable {
+	return m.factory.Pipeline()
+}
+
+func (m *mock) ExpectAuth(password string) *ExpectedStatus {
+	e := &ExpectedStatus{}
+	e.cmd = m.statefulCmds().Auth(m.ctx, password)
+	m.pushExpect(e)
+	return e
+}
+
+func (m *mock) ExpectAuthACL(username, password string) *ExpectedStatus {
+	e := &ExpectedStatus{}
+	e.cmd = m.statefulCmds().AuthACL(m.ctx, username, password)
+	m.pushExpect(e)
+	return e
+}
+
+func (m *mock) ExpectSelect(index int) *ExpectedStatus {
+	e := &ExpectedStatus{}
+	e.cmd = m.statefulCmds().Select(m.ctx, index)
+	m.pushExpect(e)
+	return e
+}
+
+func (m *mock) ExpectSwapDB(index1, index2 int) *ExpectedStatus {
+	e := &ExpectedStatus{}
+	e.cmd = m.statefulCmds().SwapDB(m.ctx, index1, index2)
+	m.pushExpect(e)
+	return e
+}
+
+func (m *mock) ExpectClientSetName(name string) *ExpectedBool {
+	e := &ExpectedBool{}
+	e.cmd = m.statefulCmds().ClientSetName(m.ctx, name)
+	m.pushExpect(e)
+	return e
+}
+
+func (m *mock) ExpectHello(ver int, username, password, clientName string) *ExpectedMapStringInterface {
+	e := &ExpectedMapStringInterface{}
+	e.cmd = m.statefulCmds().Hello(m.ctx, ver, username, password, clientName)
+	m.pushExpect(e)
+	return e
+}
+
 func (m *mock) ExpectDo(args ...interface{}) *ExpectedCmd {
 	e := &ExpectedCmd{}
 
@@ -1112,6 +2062,7 @@ func (m *mock) ExpectHRandFieldWithValues(key string, count int) *ExpectedKeyVal
 
 func (m *mock) ExpectBLPop(timeout time.Duration, keys ...string) *ExpectedStringSlice {
 	e := &ExpectedStringSlice{}
+	e.timeoutArg = -1
 	e.cmd = m.factory.BLPop(m.ctx, timeout, keys...)
 	m.pushExpect(e)
 	return e
@@ -1119,6 +2070,7 @@ func (m *mock) ExpectBLPop(timeout time.Duration, keys ...string) *ExpectedStrin
 
 func (m *mock) ExpectBLMPop(timeout time.Duration, direction string, count int64, keys ...string) *ExpectedKeyValues {
 	e := &ExpectedKeyValues{}
+	e.timeoutArg = 1
 	e.cmd = m.factory.BLMPop(m.ctx, timeout, direction, count, keys...)
 	m.pushExpect(e)
 	return e
@@ -1126,6 +2078,7 @@ func (m *mock) ExpectBLMPop(timeout time.Duration, direction string, count int64
 
 func (m *mock) ExpectBRPop(timeout time.Duration, keys ...string) *ExpectedStringSlice {
 	e := &ExpectedStringSlice{}
+	e.timeoutArg = -1
 	e.cmd = m.factory.BRPop(m.ctx, timeout, keys...)
 	m.pushExpect(e)
 	return e
@@ -1133,6 +2086,7 @@ func (m *mock) ExpectBRPop(timeout time.Duration, keys ...string) *ExpectedStrin
 
 func (m *mock) ExpectBRPopLPush(source, destination string, timeout time.Duration) *ExpectedString {
 	e := &ExpectedString{}
+	e.timeoutArg = -1
 	e.cmd = m.factory.BRPopLPush(m.ctx, source, destination, timeout)
 	m.pushExpect(e)
 	return e
@@ -1301,6 +2255,7 @@ func (m *mock) ExpectLMove(source, destination, srcpos, destpos string) *Expecte
 
 func (m *mock) ExpectBLMove(source, destination, srcpos, destpos string, timeout time.Duration) *ExpectedString {
 	e := &ExpectedString{}
+	e.timeoutArg = -1
 	e.cmd = m.factory.BLMove(m.ctx, source, destination, srcpos, destpos, timeout)
 	m.pushExpect(e)
 	return e
@@ -1662,6 +2617,7 @@ func (m *mock) ExpectXInfoConsumers(key string, group string) *ExpectedXInfoCons
 
 func (m *mock) ExpectBZPopMax(timeout time.Duration, keys ...string) *ExpectedZWithKey {
 	e := &ExpectedZWithKey{}
+	e.timeoutArg = -1
 	e.cmd = m.factory.BZPopMax(m.ctx, timeout, keys...)
 	m.pushExpect(e)
 	return e
@@ -1669,6 +2625,7 @@ func (m *mock) ExpectBZPopMax(timeout time.Duration, keys ...string) *ExpectedZW
 
 func (m *mock) ExpectBZPopMin(timeout time.Duration, keys ...string) *ExpectedZWithKey {
 	e := &ExpectedZWithKey{}
+	e.timeoutArg = -1
 	e.cmd = m.factory.BZPopMin(m.ctx, timeout, keys...)
 	m.pushExpect(e)
 	return e
@@ -1676,6 +2633,7 @@ func (m *mock) ExpectBZPopMin(timeout time.Duration, keys ...string) *ExpectedZW
 
 func (m *mock) ExpectBZMPop(timeout time.Duration, order string, count int64, keys ...string) *ExpectedZSliceWithKey {
 	e := &ExpectedZSliceWithKey{}
+	e.timeoutArg = 1
 	e.cmd = m.factory.BZMPop(m.ctx, timeout, order, count, keys...)
 	m.pushExpect(e)
 	return e
@@ -2285,6 +3243,14 @@ func (m *mock) ExpectEvalShaRO(sha1 string, keys []string, args ...interface{})
 	return e
 }
 
+func (m *mock) ExpectScript(script *redis.Script, keys []string, args ...interface{}) *ExpectedScript {
+	e := &ExpectedScript{hash: script.Hash()}
+	e.cmd = script.EvalSha(m.ctx, m.factory, keys, args...)
+	e.evalCmd = script.Eval(m.ctx, m.factory, keys, args...)
+	m.pushExpect(e)
+	return e
+}
+
 func (m *mock) ExpectScriptExists(hashes ...string) *ExpectedBoolSlice {
 	e := &ExpectedBoolSlice{}
 	e.cmd = m.factory.ScriptExists(m.ctx, hashes...)
@@ -2648,6 +3614,49 @@ func (m *mock) ExpectFunctionRestore(libDump string) *ExpectedString {
 	return e
 }
 
+func (m *mock) ExpectFunctionKill() *ExpectedStatus {
+	e := &ExpectedStatus{}
+	e.cmd = redis.NewStatusCmd(m.ctx, "function", "kill")
+	m.pushExpect(e)
+	return e
+}
+
+func (m *mock) ExpectFunctionStats() *ExpectedFunctionStats {
+	e := &ExpectedFunctionStats{}
+	e.cmd = redis.NewCmd(m.ctx, "function", "stats")
+	m.pushExpect(e)
+	return e
+}
+
+func (m *mock) ExpectFCall(function string, keys []string, args ...interface{}) *ExpectedFCall {
+	return m.expectFCall("fcall", function, keys, args...)
+}
+
+func (m *mock) ExpectFCallRO(function string, keys []string, args ...interface{}) *ExpectedFCall {
+	return m.expectFCall("fcall_ro", function, keys, args...)
+}
+
+func (m *mock) expectFCall(name, function string, keys []string, args ...interface{}) *ExpectedFCall {
+	cmdArgs := make([]interface{}, 3, 3+len(keys)+len(args))
+	cmdArgs[0] = name
+	cmdArgs[1] = function
+	cmdArgs[2] = len(keys)
+	for _, key := range keys {
+		cmdArgs = append(cmdArgs, key)
+	}
+	cmdArgs = append(cmdArgs, args...)
+
+	e := &ExpectedFCall{function: function, functions: m.functions}
+	e.cmd = redis.NewCmd(m.ctx, cmdArgs...)
+	m.pushExpect(e)
+	return e
+}
+
+func (m *mock) PreloadFunctions(code string) error {
+	_, err := m.functions.load(code, true)
+	return err
+}
+
 // ------------------------------------------------------------------------
 
 func (m *mock) ExpectACLDryRun(username string, command ...interface{}) *ExpectedString {
@@ -1506,6 +1506,54 @@ func operationFunctionListCmd(base baseMock, expected func() *ExpectedFunctionLi
 	Expect(val).To(Equal(libs))
 }
 
+func operationFunctionStatsCmd(base baseMock, expected func() *ExpectedFunctionStats, actual func() *redis.Cmd) {
+	var (
+		setErr = errors.New("function stats cmd error")
+		val    interface{}
+		err    error
+	)
+
+	base.ClearExpect()
+	expected().SetErr(setErr)
+	val, err = actual().Result()
+	Expect(err).To(Equal(setErr))
+	Expect(val).To(BeNil())
+
+	base.ClearExpect()
+	expected()
+	val, err = actual().Result()
+	Expect(err).To(HaveOccurred())
+	Expect(val).To(BeNil())
+
+	base.ClearExpect()
+	expected().SetVal(FunctionStats{
+		RunningScript: &RunningFunction{
+			Name:     "slow",
+			Command:  []string{"fcall", "slow", "0"},
+			Duration: 1500 * time.Millisecond,
+		},
+		Engines: []FunctionEngine{{Language: "LUA", LibrariesCount: 1, FunctionsCount: 2}},
+	})
+	val, err = actual().Result()
+	Expect(err).NotTo(HaveOccurred())
+	Expect(val).To(Equal([]interface{}{
+		"running_script", []interface{}{
+			"name", "slow",
+			"command", []interface{}{"fcall", "slow", "0"},
+			"duration_ms", int64(1500),
+		},
+		"engines", []interface{}{
+			"LUA", []interface{}{"libraries_count", int64(1), "functions_count", int64(2)},
+		},
+	}))
+
+	base.ClearExpect()
+	expected().SetVal(FunctionStats{})
+	val, err = actual().Result()
+	Expect(err).NotTo(HaveOccurred())
+	Expect(val).To(Equal([]interface{}{"running_script", nil, "engines", []interface{}{}}))
+}
+
 func operationLCSCmd(base baseMock, expected func() *ExpectedLCS, actual func() *redis.LCSCmd) {
 	var (
 		setErr = errors.New("lcs cmd error")